@@ -2,13 +2,14 @@ package update
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -17,164 +18,183 @@ import (
 
 var (
 	openFile = os.OpenFile
+	flushDir = syncDir
 )
 
 // Apply performs an update of the current executable (or opts.TargetFile, if set) with the contents of the given io.Reader.
 //
 // Apply performs the following actions to ensure a safe cross-platform update:
 //
-// 1. If configured, applies the contents of the update io.Reader as a binary patch.
+// 1. Creates a new file, /path/to/.target.new with the TargetMode. The contents of the update io.Reader
+// (or, if configured, the result of applying it as a binary patch) are streamed directly into this file,
+// so the new executable is never held in memory in its entirety. With DurabilitySync, the file's data
+// is flushed to stable storage before it is closed. A crash during this step leaves at most a partial
+// /path/to/.target.new behind; /path/to/target is untouched.
 //
-// 2. If configured, computes the checksum of the new executable and verifies it matches.
+// 2. If configured, computes the checksum of the new executable while it is written and verifies it matches.
 //
-// 3. If configured, verifies the signature with a public key.
+// 3. If configured, verifies the signature with a public key. A failure of this or the previous step
+// is reported as a *VerificationError.
 //
-// 4. Creates a new file, /path/to/.target.new with the TargetMode with the contents of the updated file
+// 4. If configured, checks /path/to/.target.new with the Validator and the BuildInfoPolicy. If PreserveAttributes is set, copies the mode,
+// owner, group and extended attributes of /path/to/target to /path/to/.target.new. If any of this or either
+// verification fails, removes /path/to/.target.new and returns an error without touching the target.
+// Steps 2 through 4 don't modify the file system in a way that matters to crash consistency.
 //
-// 5. Renames /path/to/target to /path/to/.target.old
+// 5. Renames /path/to/target to /path/to/.target.old. With DurabilitySync, the directory is flushed afterwards.
+// A crash after this step and before step 6 leaves no file at /path/to/target, but the complete
+// previous executable at /path/to/.target.old.
 //
-// 6. Renames /path/to/.target.new to /path/to/target
+// 6. Renames /path/to/.target.new to /path/to/target. With DurabilitySync, the directory is flushed afterwards,
+// and a crash at any later point leaves the complete new executable at /path/to/target. Without
+// DurabilitySync, a power loss shortly after this step may leave /path/to/target empty or truncated
+// on file systems that delay writing file data.
 //
 // 7. If the final rename is successful, deletes /path/to/.target.old, returns no error. On Windows,
 // the removal of /path/to/target.old always fails, so instead Apply hides the old file instead.
+// A crash during this step may leave /path/to/.target.old behind; the next Apply removes it.
 //
 // 8. If the final rename fails, attempts to roll back by renaming /path/to/.target.old
-// back to /path/to/target.
+// back to /path/to/target. With DurabilitySync, the directory is flushed afterwards, so a crash
+// at any later point leaves the complete previous executable at /path/to/target.
+//
+// With SwapAtomic on platforms other than Windows, steps 5 through 8 are instead:
+//
+// 5. If OldSavePath is set, hard links /path/to/target to OldSavePath, or copies it there if it can't be linked.
+//
+// 6. Renames /path/to/.target.new over /path/to/target in a single atomic operation, so that /path/to/target
+// always resolves to either the complete previous or the complete new executable.
+//
+// 7. If the rename fails, removes the file created in step 5. No roll back is ever needed.
+//
+// With DurabilitySync, the affected directories are flushed after steps 5 and 6 as described above.
+// If the flush after step 6 fails, the update is live already: Apply keeps the file created in step 5
+// and returns a *DurabilityError.
+//
+// If HealthCheck is set, Apply runs the new executable after it was swapped in, before removing
+// /path/to/.target.old. If the executable exits with a non-zero status, crashes or doesn't exit in time,
+// Apply moves /path/to/.target.old (or OldSavePath) back to /path/to/target and returns a *HealthCheckError.
 //
 // If the roll back operation fails, the file system is left in an inconsistent state (betweet steps 5 and 6) where
 // there is no new executable file and the old executable file could not be be moved to its original location. In this
 // case you should notify the user of the bad news and ask them to recover manually. Applications can determine whether
 // the rollback failed by calling RollbackError, see the documentation on that function for additional detail.
 func Apply(update io.Reader, opts Options) error {
-	// validate
-	verify := false
-	switch {
-	case opts.Signature != nil && opts.PublicKey != nil:
-		// okay
-		verify = true
-	case opts.Signature != nil:
-		return errors.New("no public key to verify signature with")
-	case opts.PublicKey != nil:
-		return errors.New("No signature to verify with")
-	}
+	return ApplyContext(context.Background(), update, opts)
+}
 
-	// set defaults
-	if opts.Hash == 0 {
-		opts.Hash = crypto.SHA256
-	}
-	if opts.Verifier == nil {
-		opts.Verifier = NewECDSAVerifier()
-	}
-	if opts.TargetMode == 0 {
-		opts.TargetMode = 0755
+// ApplyContext is like Apply but stops reading, patching and writing the update as soon
+// as ctx is cancelled or its deadline expires. If the update io.Reader is also an io.Closer,
+// it is closed on cancellation so that a Read blocked on a stalled connection returns.
+//
+// Cancellation is only honored before the target is swapped (steps 1 through 4 in the
+// documentation of Apply). When cancelled, ApplyContext removes /path/to/.target.new and returns
+// an error wrapping ctx.Err() without touching /path/to/target or /path/to/.target.old.
+func ApplyContext(ctx context.Context, update io.Reader, opts Options) error {
+	verify, err := opts.setup()
+	if err != nil {
+		return err
 	}
 
 	// get target path
-	var err error
 	opts.TargetPath, err = opts.getPath()
 	if err != nil {
 		return err
 	}
 
-	var newBytes []byte
-	if opts.Patcher != nil {
-		if newBytes, err = opts.applyPatch(update); err != nil {
-			return err
-		}
-	} else {
-		// no patch to apply, go on through
-		if newBytes, err = ioutil.ReadAll(update); err != nil {
-			return err
-		}
+	// refuse to roll back to an older version before doing any work
+	floorPath := versionFloorPath(opts.TargetPath)
+	floor, err := opts.checkVersionFloor(floorPath)
+	if err != nil {
+		return err
 	}
 
-	// verify checksum if requested
-	if opts.Checksum != nil {
-		if err = opts.verifyChecksum(newBytes); err != nil {
+	switch opts.Symlink {
+	case SymlinkFollow:
+		if opts.TargetPath, err = filepath.EvalSymlinks(opts.TargetPath); err != nil {
 			return err
 		}
+		err = opts.replace(ctx, update, verify)
+	case SymlinkRetarget:
+		err = opts.retarget(ctx, update, verify)
+	default:
+		err = opts.replace(ctx, update, verify)
 	}
-
-	if verify {
-		if err = opts.verifySignature(newBytes); err != nil {
-			return err
-		}
+	if err != nil {
+		return err
 	}
 
+	return opts.raiseVersionFloor(floorPath, floor)
+}
+
+// replace replaces the file at TargetPath with the update.
+func (o *Options) replace(ctx context.Context, update io.Reader, verify bool) error {
 	// get the directory the executable exists in
-	updateDir := filepath.Dir(opts.TargetPath)
-	filename := filepath.Base(opts.TargetPath)
+	updateDir := filepath.Dir(o.TargetPath)
+	filename := filepath.Base(o.TargetPath)
 
-	// Copy the contents of newbinary to a new executable file
+	// Stream the contents of the update into a new executable file
 	newPath := filepath.Join(updateDir, fmt.Sprintf(".%s.new", filename))
-	fp, err := openFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, opts.TargetMode)
-	if err != nil {
-		return err
-	}
-	defer fp.Close()
-
-	_, err = io.Copy(fp, bytes.NewReader(newBytes))
-	if err != nil {
+	if err := o.prepare(ctx, update, newPath, verify); err != nil {
 		return err
 	}
 
-	// if we don't call fp.Close(), windows won't let us move the new executable
-	// because the file will still be "in use"
-	fp.Close()
-
 	// this is where we'll move the executable to so that we can swap in the updated replacement
-	oldPath := opts.OldSavePath
-	removeOld := opts.OldSavePath == ""
+	oldPath := o.OldSavePath
+	removeOld := o.OldSavePath == ""
 	if removeOld {
 		oldPath = filepath.Join(updateDir, fmt.Sprintf(".%s.old", filename))
 	}
 
-	// delete any existing old exec file - this is necessary on Windows for two reasons:
-	// 1. after a successful update, Windows can't remove the .old file because the process is still running
-	// 2. windows rename operations fail if the destination file already exists
-	_ = os.Remove(oldPath)
-
-	// move the existing executable to a new file in the same directory
-	err = os.Rename(opts.TargetPath, oldPath)
-	if err != nil {
-		return err
+	if o.HealthCheck == nil {
+		return o.discardStaged(newPath, o.swap(newPath, oldPath, removeOld))
 	}
 
-	// move the new exectuable in to become the new program
-	err = os.Rename(newPath, opts.TargetPath)
-
+	// keep the old executable around until the new one proved that it works
+	if err := o.swap(newPath, oldPath, false); err != nil {
+		return o.discardStaged(newPath, err)
+	}
+	err := o.checkHealth(o.TargetPath, func() error {
+		failedPath := filepath.Join(updateDir, fmt.Sprintf(".%s.failed", filename))
+		return o.swap(oldPath, failedPath, true)
+	})
 	if err != nil {
-		// move unsuccessful
-		//
-		// The filesystem is now in a bad state. We have successfully
-		// moved the existing binary to a new location, but we couldn't move the new
-		// binary to take its place. That means there is no file where the current executable binary
-		// used to be!
-		// Try to rollback by restoring the old binary to its original path.
-		rerr := os.Rename(oldPath, opts.TargetPath)
-		if rerr != nil {
-			return &rollbackErr{err, rerr}
-		}
-
 		return err
 	}
-
-	// move successful, remove the old binary if needed
 	if removeOld {
-		errRemove := os.Remove(oldPath)
+		discardFile(oldPath)
+	}
+	return nil
+}
 
-		// windows has trouble with removing old binaries, so hide it instead
-		if errRemove != nil {
-			_ = hideFile(oldPath)
-		}
+// discardStaged removes the staged update at newPath if swapping it in failed with err.
+// It's kept if the failed rollback left it as the only copy of the update.
+func (o *Options) discardStaged(newPath string, err error) error {
+	if _, ok := err.(*rollbackErr); err != nil && !ok {
+		_ = os.Remove(newPath)
 	}
+	return err
+}
 
-	return nil
+// DurabilityError is returned by Apply when the update is in place, but flushing the directory
+// entries to stable storage failed with Err. No HealthCheck is run, and the replaced executable
+// is kept at OldSavePath, if it's set, so Rollback can restore it. Only SwapAtomic reports this
+// error: SwapRename restores the replaced executable instead.
+type DurabilityError struct {
+	Err error
 }
 
-// RollbackError takes an error value returned by Apply and returns the error, if any,
+func (e *DurabilityError) Error() string {
+	return fmt.Sprintf("update applied, but failed to flush it to stable storage: %v", e.Err)
+}
+
+func (e *DurabilityError) Unwrap() error {
+	return e.Err
+}
+
+// RollbackError takes an error value returned by Apply or Rollback and returns the error, if any,
 // that occurred when attempting to roll back from a failed update. Applications should
-// always call this function on any non-nil errors returned by Apply.
+// always call this function on any non-nil errors returned by Apply or Rollback.
 //
 // If no rollback was needed or if the rollback was successful, RollbackError returns nil,
 // otherwise it returns the error encountered when trying to roll back.
@@ -182,17 +202,59 @@ func RollbackError(err error) error {
 	if err == nil {
 		return nil
 	}
-	if rerr, ok := err.(*rollbackErr); ok {
+	switch rerr := err.(type) {
+	case *rollbackErr:
 		return rerr.rollbackErr
+	case *HealthCheckError:
+		return rerr.RollbackErr
 	}
 	return nil
 }
 
+// VerificationError is returned by Apply when the update doesn't match its Checksum or
+// its signature doesn't verify. The target is left untouched.
+type VerificationError struct {
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
 type rollbackErr struct {
 	error             // original error
 	rollbackErr error // error encountered while rolling back
 }
 
+// Durability controls how hard Apply works to make an update survive a crash or power loss.
+type Durability int
+
+const (
+	// DurabilityNone leaves flushing the update to stable storage up to the operating system.
+	DurabilityNone Durability = iota
+
+	// DurabilitySync flushes the new file's data before it is swapped in and the containing
+	// directory after each rename. See the documentation of Apply for the resulting guarantees.
+	DurabilitySync
+)
+
+// SwapStrategy selects how Apply moves the new file into place.
+type SwapStrategy int
+
+const (
+	// SwapRename renames the target out of the way and then renames the new file into its place.
+	// It works on all platforms, but there's a short window during which no file exists at the target path.
+	SwapRename SwapStrategy = iota
+
+	// SwapAtomic replaces the target with a single atomic rename(2). It is not supported on Windows,
+	// where Apply falls back to SwapRename.
+	SwapAtomic
+)
+
 type Options struct {
 	// TargetPath defines the path to the file to update.
 	// The emptry string means 'the executable file of the running program'.
@@ -201,6 +263,15 @@ type Options struct {
 	// Create TargetPath replacement with this file mode. If zero, defaults to 0755.
 	TargetMode os.FileMode
 
+	// Copy the mode, owner, group and the extended attributes named by PreserveXattrs
+	// of the existing TargetPath to its replacement, overriding TargetMode. Apply fails
+	// with a *PreserveError if any of them can't be copied.
+	PreserveAttributes bool
+
+	// Extended attributes copied when PreserveAttributes is set. If nil, defaults to
+	// security.capability and security.selinux on Linux and none elsewhere.
+	PreserveXattrs []string
+
 	// Checksum of the new binary to verify against. If nil, no checksum or signature verification is done.
 	Checksum []byte
 
@@ -210,12 +281,39 @@ type Options struct {
 	// Signature to verify the updated file. If nil, no signature verification is done.
 	Signature []byte
 
-	// Pluggable signature verification algorithm. If nil, ECDSA is used.
+	// Public keys trusted to sign updates, as an alternative to a single PublicKey.
+	// Use with Signatures instead of Signature.
+	Keyring []TrustedKey
+
+	// Signatures of the update made with keys in the Keyring.
+	Signatures []KeySignature
+
+	// Number of distinct trusted keys that must have signed the update. If zero, defaults to 1.
+	// A successor authorized by Transitions counts as the key of the Keyring it descends from.
+	Threshold int
+
+	// Statements authorizing successors of the keys in the Keyring, which are then trusted as well.
+	Transitions []KeyTransition
+
+	// IDs of keys that must not be trusted, e.g. because they were compromised. Revoked keys neither
+	// count towards the Threshold nor authorize successors, even if they are in the Keyring.
+	RevokedKeys []string
+
+	// Pluggable signature verification algorithm. If nil, the algorithm is picked
+	// from the type of PublicKey, see NewAutoVerifier.
 	Verifier Verifier
 
 	// Use this hash function to generate the checksum. If not set, SHA256 is used.
 	Hash crypto.Hash
 
+	// Pluggable check of the new file before it replaces TargetPath. If nil, no check is done.
+	// See NewExecutableValidator.
+	Validator Validator
+
+	// Checks of the Go build information of the new executable against that of TargetPath.
+	// If zero, no checks are done.
+	BuildInfoPolicy BuildInfoPolicy
+
 	// If nil, treat the update as a complete replacement for the contents of the file at TargetPath.
 	// If non-nil, treat the update contents as a patch and use this object to apply the patch.
 	Patcher Patcher
@@ -223,6 +321,38 @@ type Options struct {
 	// Store the old executable file at this path after a successful update.
 	// The empty string means the old executable file will be removed after the update.
 	OldSavePath string
+
+	// Store the executable replaced by Rollback at this path.
+	// The empty string means it will be removed after the rollback.
+	RollbackSavePath string
+
+	// Durability controls whether the update is flushed to stable storage. If zero, defaults to DurabilityNone.
+	Durability Durability
+
+	// Swap selects how the new file replaces the target. If zero, defaults to SwapRename.
+	Swap SwapStrategy
+
+	// Symlink selects what happens when TargetPath is a symbolic link. If zero, defaults to SymlinkReplace.
+	// Note that on most platforms the path of the running executable has its links resolved already,
+	// so TargetPath must be set explicitly to update through a link.
+	Symlink SymlinkPolicy
+
+	// The path to install the new version at when Symlink is SymlinkRetarget, e.g. /opt/tool/1.5/tool.
+	// A relative path is relative to the directory containing TargetPath.
+	LinkDest string
+
+	// If non-nil, run the new executable after the swap and restore the previous one if it fails.
+	HealthCheck *HealthCheck
+
+	// Monotonic version number of the update. If non-zero, the signature must be of the digest
+	// returned by VersionedChecksum rather than of the plain checksum, and the highest version ever
+	// installed is recorded in /path/to/.target.version. Once that file exists, Apply rejects updates
+	// with a lower (or no) Version with a *DowngradeError. A Version requires a Signature or Keyring
+	// and can't be used with a PayloadVerifier, which couldn't verify it.
+	Version uint64
+
+	// Install the update even if its Version is lower than the highest version ever installed.
+	AllowDowngrade bool
 }
 
 // CheckPermissions determines whether the process has the correct permissions to
@@ -253,6 +383,7 @@ func (o *Options) CheckPermissions() error {
 // SetPublicKeyPEM is a convenience method to set the PublicKey property
 // used for checking a completed update's signature by parsing a
 // Public Key formatted as PEM data.
+// It accepts PKIX encoded RSA, ECDSA, Ed25519 and DSA keys.
 func (o *Options) SetPublicKeyPEM(pembytes []byte) error {
 	block, _ := pem.Decode(pembytes)
 	if block == nil {
@@ -275,48 +406,263 @@ func (o *Options) getPath() (string, error) {
 	}
 }
 
-func (o *Options) applyPatch(patch io.Reader) ([]byte, error) {
-	// open the file to patch
-	old, err := os.Open(o.TargetPath)
+// setup validates the options and fills in defaults. It reports whether
+// a signature must be verified.
+func (o *Options) setup() (bool, error) {
+	// validate
+	verify := false
+	switch {
+	case o.Signature != nil && o.PublicKey != nil:
+		// okay
+		verify = true
+	case o.Signature != nil:
+		return false, errors.New("no public key to verify signature with")
+	case o.PublicKey != nil:
+		return false, errors.New("No signature to verify with")
+	}
+
+	if len(o.Keyring) > 0 || len(o.Signatures) > 0 {
+		if verify {
+			return false, errors.New("use either PublicKey and Signature or Keyring and Signatures")
+		}
+		if err := o.validateKeyring(); err != nil {
+			return false, err
+		}
+		verify = true
+	}
+
+	// set defaults
+	if o.Hash == 0 {
+		o.Hash = crypto.SHA256
+	}
+	if o.Verifier == nil {
+		o.Verifier = NewAutoVerifier()
+	}
+
+	// an unverified version could raise the floor beyond any legitimate update
+	if o.Version != 0 {
+		if !verify {
+			return false, errors.New("Version requires a Signature or Keyring to verify it with")
+		}
+		if _, ok := o.Verifier.(PayloadVerifier); ok && len(o.Keyring) == 0 {
+			return false, errors.New("Version can't be verified with a signature of the update itself")
+		}
+	}
+
+	if o.TargetMode == 0 {
+		o.TargetMode = 0755
+	}
+	return verify, nil
+}
+
+// prepare writes and verifies the new contents of the target at path and applies
+// everything to it that must happen before it's swapped in. If it returns an error,
+// nothing is left at path.
+func (o *Options) prepare(ctx context.Context, update io.Reader, path string, verify bool) error {
+	if err := o.stage(ctx, update, path, verify); err != nil {
+		if ctx.Err() != nil {
+			return canceledErr(ctx)
+		}
+		return err
+	}
+
+	if o.Validator != nil {
+		if err := o.Validator.Validate(path, o.TargetPath); err != nil {
+			_ = os.Remove(path)
+			return err
+		}
+	}
+
+	if err := o.checkBuildInfo(path); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	if o.PreserveAttributes {
+		if err := o.preserveAttributes(path); err != nil {
+			_ = os.Remove(path)
+			return err
+		}
+	}
+
+	// last chance to abort before anything on disk but the new file is modified
+	if ctx.Err() != nil {
+		_ = os.Remove(path)
+		return canceledErr(ctx)
+	}
+	return nil
+}
+
+// stage writes the new contents of the target to path, hashing them as they
+// are written, and verifies the checksum and signature before returning. The
+// new contents are never held in memory in their entirety. If anything fails
+// or ctx is cancelled, the partially written file at path is removed.
+func (o *Options) stage(ctx context.Context, update io.Reader, path string, verify bool) (err error) {
+	var h hash.Hash
+	if o.Checksum != nil || verify {
+		if !o.Hash.Available() {
+			return errors.New("requested hash function not available")
+		}
+		h = o.Hash.New()
+	}
+
+	fp, err := openFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, o.TargetMode)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer old.Close()
+	defer func() {
+		fp.Close()
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
 
-	// apply the patch
-	var applied bytes.Buffer
-	if err = o.Patcher.Patch(old, &applied, patch); err != nil {
-		return nil, err
+	var w io.Writer = fp
+	if h != nil {
+		w = io.MultiWriter(fp, h)
+	}
+	// unblock a Read that is stuck waiting on the network
+	if c, ok := update.(io.Closer); ok {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.Close()
+			case <-done:
+			}
+		}()
 	}
+	w = &ctxWriter{ctx, w}
+	update = &ctxReader{ctx, update}
 
-	return applied.Bytes(), nil
+	if o.Patcher != nil {
+		err = o.applyPatch(update, w)
+	} else {
+		// no patch to apply, go on through
+		_, err = io.Copy(w, update)
+	}
+	if err != nil {
+		return err
+	}
+
+	if o.Durability == DurabilitySync {
+		if err = fp.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// if we don't call fp.Close(), windows won't let us move the new executable
+	// because the file will still be "in use"
+	if err = fp.Close(); err != nil {
+		return err
+	}
+
+	if h == nil {
+		return nil
+	}
+	checksum := h.Sum(nil)
+
+	// verify checksum if requested
+	if o.Checksum != nil {
+		if err = o.verifyChecksum(checksum); err != nil {
+			return &VerificationError{err}
+		}
+	}
+
+	if verify {
+		if err = o.verifySignature(checksum, path); err != nil {
+			return &VerificationError{err}
+		}
+	}
+	return nil
 }
 
-func (o *Options) verifyChecksum(updated []byte) error {
-	checksum, err := checksumFor(o.Hash, updated)
+// syncDirs flushes the given directories if the options ask for durability.
+func (o *Options) syncDirs(dirs ...string) error {
+	if o.Durability != DurabilitySync {
+		return nil
+	}
+	for i, dir := range dirs {
+		if i > 0 && dir == dirs[i-1] {
+			continue
+		}
+		if err := flushDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Options) applyPatch(patch io.Reader, applied io.Writer) error {
+	// open the file to patch
+	old, err := os.Open(o.TargetPath)
 	if err != nil {
 		return err
 	}
+	defer old.Close()
+
+	// apply the patch
+	return o.Patcher.Patch(old, applied, patch)
+}
 
+func (o *Options) verifyChecksum(checksum []byte) error {
 	if !bytes.Equal(o.Checksum, checksum) {
 		return fmt.Errorf("Updated file has wrong checksum. Expected: %x, got: %x", o.Checksum, checksum)
 	}
 	return nil
 }
 
-func (o *Options) verifySignature(updated []byte) error {
-	checksum, err := checksumFor(o.Hash, updated)
-	if err != nil {
-		return err
+// verifySignature verifies the signature of the checksum of the new file at path,
+// or of the file itself if the Verifier is a PayloadVerifier.
+func (o *Options) verifySignature(checksum []byte, path string) error {
+	if pv, ok := o.Verifier.(PayloadVerifier); ok && len(o.Keyring) == 0 {
+		fp, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		return pv.VerifyPayload(fp, o.Signature, o.PublicKey)
+	}
+
+	if o.Version != 0 {
+		var err error
+		if checksum, err = VersionedChecksum(o.Hash, checksum, o.Version); err != nil {
+			return err
+		}
+	}
+	if len(o.Keyring) > 0 {
+		return o.verifyThreshold(checksum)
 	}
 	return o.Verifier.VerifySignature(checksum, o.Signature, o.Hash, o.PublicKey)
 }
 
-func checksumFor(h crypto.Hash, payload []byte) ([]byte, error) {
-	if !h.Available() {
-		return nil, errors.New("requested hash function not available")
+func canceledErr(ctx context.Context) error {
+	return fmt.Errorf("update aborted: %w", ctx.Err())
+}
+
+// ctxReader fails all reads once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ctxWriter fails all writes once its context is done.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
 	}
-	hash := h.New()
-	hash.Write(payload) // guaranteed not to error
-	return hash.Sum([]byte{}), nil
+	return w.w.Write(p)
 }
@@ -2,15 +2,22 @@ package update
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/inconshreveable/go-update/internal/binarydist"
 )
@@ -79,6 +86,285 @@ func TestApplyOldSavePath(t *testing.T) {
 	cleanup(oldfName)
 }
 
+func TestApplyDurabilitySync(t *testing.T) {
+	fName := "TestApplyDurabilitySync"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Durability: DurabilitySync,
+	})
+	validateUpdate(fName, err, t)
+}
+
+func TestApplySwapAtomic(t *testing.T) {
+	fName := "TestApplySwapAtomic"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	oldfName := "TestApplySwapAtomicOld"
+	defer cleanup(oldfName)
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath:  fName,
+		OldSavePath: oldfName,
+		Swap:        SwapAtomic,
+	})
+	validateUpdate(fName, err, t)
+
+	buf, err := ioutil.ReadFile(oldfName)
+	if err != nil {
+		t.Fatalf("Failed to read the old file: %v", err)
+	}
+	if !bytes.Equal(buf, oldFile) {
+		t.Fatalf("Old file was not saved! Bytes read: %v, Bytes expected: %v", buf, oldFile)
+	}
+}
+
+func TestApplySwapAtomicSyncFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SwapAtomic is not supported on Windows")
+	}
+	fName := "TestApplySwapAtomicSyncFailure"
+	defer cleanup(fName)
+	oldfName := "TestApplySwapAtomicSyncFailureOld"
+	defer cleanup(oldfName)
+
+	// fail the nth directory flush: first the one after saving the old file,
+	// then the one after renaming the update into place
+	for fail := 1; fail <= 2; fail++ {
+		writeOldFile(fName, t)
+		calls := 0
+		flushDir = func(dir string) error {
+			calls++
+			if calls == fail {
+				return errors.New("injected sync failure")
+			}
+			return nil
+		}
+		err := Apply(bytes.NewReader(newFile), Options{
+			TargetPath:  fName,
+			OldSavePath: oldfName,
+			Swap:        SwapAtomic,
+			Durability:  DurabilitySync,
+		})
+		flushDir = syncDir
+
+		if _, serr := os.Stat(fmt.Sprintf(".%s.new", fName)); !os.IsNotExist(serr) {
+			t.Fatalf("Staged update was left behind: %v", serr)
+		}
+		target, _ := ioutil.ReadFile(fName)
+		saved, serr := ioutil.ReadFile(oldfName)
+		if fail == 1 {
+			if err == nil || !bytes.Equal(target, oldFile) || !os.IsNotExist(serr) {
+				t.Fatalf("Failure before the swap modified the target: %v", err)
+			}
+			continue
+		}
+		if _, ok := err.(*DurabilityError); !ok {
+			t.Fatalf("Expected a *DurabilityError, got: %v", err)
+		}
+		if !bytes.Equal(target, newFile) || !bytes.Equal(saved, oldFile) {
+			t.Fatalf("Expected the update in place and the old file saved, got: %v, %v", target, saved)
+		}
+		os.Remove(oldfName)
+	}
+}
+
+func TestApplyPreserveAttributes(t *testing.T) {
+	fName := "TestApplyPreserveAttributes"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	if err := os.Chmod(fName, 0750); err != nil {
+		t.Fatalf("Failed to change mode for testing preparation: %v", err)
+	}
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath:         fName,
+		PreserveAttributes: true,
+		PreserveXattrs:     []string{},
+	})
+	validateUpdate(fName, err, t)
+
+	info, err := os.Stat(fName)
+	if err != nil {
+		t.Fatalf("Failed to stat file post-update: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0750 {
+		t.Fatalf("Mode was not preserved! Got: %v, expected: %v", info.Mode().Perm(), os.FileMode(0750))
+	}
+}
+
+// writeVersionedLayout creates dir/1.0/tool with the old contents and a dir/tool link pointing to it.
+func writeVersionedLayout(dir string, t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require special privileges on Windows")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "1.0"), 0755); err != nil {
+		t.Fatalf("Failed to create directory for testing preparation: %v", err)
+	}
+	writeOldFile(filepath.Join(dir, "1.0", "tool"), t)
+
+	link := filepath.Join(dir, "tool")
+	if err := os.Symlink(filepath.Join("1.0", "tool"), link); err != nil {
+		t.Fatalf("Failed to create link for testing preparation: %v", err)
+	}
+	return link
+}
+
+func TestApplySymlinkFollow(t *testing.T) {
+	dir := "TestApplySymlinkFollow"
+	defer os.RemoveAll(dir)
+	link := writeVersionedLayout(dir, t)
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: link,
+		Symlink:    SymlinkFollow,
+	})
+	validateUpdate(filepath.Join(dir, "1.0", "tool"), err, t)
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Link was replaced: %v", err)
+	}
+}
+
+func TestApplySymlinkRetarget(t *testing.T) {
+	dir := "TestApplySymlinkRetarget"
+	defer os.RemoveAll(dir)
+	link := writeVersionedLayout(dir, t)
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: link,
+		Symlink:    SymlinkRetarget,
+		LinkDest:   filepath.Join("1.1", "tool"),
+	})
+	validateUpdate(link, err, t)
+
+	dest, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("Failed to read link post-update: %v", err)
+	}
+	if dest != filepath.Join("1.1", "tool") {
+		t.Fatalf("Link was not retargeted! Points to: %s", dest)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "1.0", "tool"))
+	if err != nil {
+		t.Fatalf("Failed to read previous version: %v", err)
+	}
+	if !bytes.Equal(buf, oldFile) {
+		t.Fatalf("Previous version was modified! Bytes read: %v, Bytes expected: %v", buf, oldFile)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	for _, swap := range []SwapStrategy{SwapRename, SwapAtomic} {
+		fName := "TestRollback"
+		oldfName := "TestRollbackOld"
+		badfName := "TestRollbackBad"
+		writeOldFile(fName, t)
+
+		opts := Options{
+			TargetPath:       fName,
+			OldSavePath:      oldfName,
+			RollbackSavePath: badfName,
+			Swap:             swap,
+		}
+		err := Apply(bytes.NewReader(newFile), opts)
+		validateUpdate(fName, err, t)
+
+		err = Rollback(opts)
+		if err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+
+		buf, err := ioutil.ReadFile(fName)
+		if err != nil {
+			t.Fatalf("Failed to read file post-rollback: %v", err)
+		}
+		if !bytes.Equal(buf, oldFile) {
+			t.Fatalf("File was not rolled back! Bytes read: %v, Bytes expected: %v", buf, oldFile)
+		}
+		validateUpdate(badfName, nil, t)
+
+		cleanup(fName)
+		cleanup(oldfName)
+		cleanup(badfName)
+	}
+}
+
+func TestRollbackNoOldSavePath(t *testing.T) {
+	fName := "TestRollbackNoOldSavePath"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	if err := Rollback(Options{TargetPath: fName}); err == nil {
+		t.Fatalf("Rolled back without an old file to roll back to!")
+	}
+}
+
+func TestApplyHealthCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("health check test relies on shell scripts")
+	}
+	healthy := []byte("#!/bin/sh\nexit 0\n")
+	tests := []struct {
+		name     string
+		script   string
+		timedOut bool
+	}{
+		{"Passes", "#!/bin/sh\n[ \"$1\" = --self-test ]\n", false},
+		{"Fails", "#!/bin/sh\nexit 3\n", false},
+		{"TimesOut", "#!/bin/sh\nsleep 5\n", true},
+	}
+
+	for _, tt := range tests {
+		fName := "TestApplyHealthCheck" + tt.name
+		if err := ioutil.WriteFile(fName, healthy, 0755); err != nil {
+			t.Fatalf("Failed to write file for testing preparation: %v", err)
+		}
+
+		err := Apply(bytes.NewReader([]byte(tt.script)), Options{
+			TargetPath:  fName,
+			HealthCheck: &HealthCheck{Args: []string{"--self-test"}, Timeout: 200 * time.Millisecond},
+		})
+		buf, rerr := ioutil.ReadFile(fName)
+		if rerr != nil {
+			t.Fatalf("%s: Failed to read file post-update: %v", tt.name, rerr)
+		}
+		cleanup(fName)
+
+		if tt.name == "Passes" {
+			if err != nil {
+				t.Fatalf("%s: Failed to update: %v", tt.name, err)
+			}
+			if string(buf) != tt.script {
+				t.Fatalf("%s: File was not updated!", tt.name)
+			}
+			continue
+		}
+
+		herr, ok := err.(*HealthCheckError)
+		if !ok {
+			t.Fatalf("%s: Expected a *HealthCheckError, got: %v", tt.name, err)
+		}
+		if herr.TimedOut != tt.timedOut {
+			t.Fatalf("%s: Expected TimedOut to be %v: %v", tt.name, tt.timedOut, herr)
+		}
+		if rerr := RollbackError(err); rerr != nil {
+			t.Fatalf("%s: Failed to roll back: %v", tt.name, rerr)
+		}
+		if !bytes.Equal(buf, healthy) {
+			t.Fatalf("%s: Previous executable was not restored! Contents: %q", tt.name, buf)
+		}
+		if _, err := os.Stat(fmt.Sprintf(".%s.old", fName)); !os.IsNotExist(err) {
+			t.Fatalf("%s: Old file was left behind: %v", tt.name, err)
+		}
+	}
+}
+
 func TestVerifyChecksum(t *testing.T) {
 	fName := "TestVerifyChecksum"
 	defer cleanup(fName)
@@ -101,9 +387,35 @@ func TestVerifyChecksumNegative(t *testing.T) {
 		TargetPath: fName,
 		Checksum:   badChecksum,
 	})
+	if _, ok := err.(*VerificationError); !ok {
+		t.Fatalf("Failed to detect bad checksum! Expected a *VerificationError, got: %v", err)
+	}
+}
+
+func TestVerifyChecksumNegativeRemovesNewFile(t *testing.T) {
+	fName := "TestVerifyChecksumNegativeRemovesNewFile"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Checksum:   []byte{0x0A, 0x0B, 0x0C, 0xFF},
+	})
 	if err == nil {
 		t.Fatalf("Failed to detect bad checksum!")
 	}
+
+	if _, err := os.Stat(fmt.Sprintf(".%s.new", fName)); !os.IsNotExist(err) {
+		t.Fatalf("Staged file was not removed after failed verification: %v", err)
+	}
+
+	buf, err := ioutil.ReadFile(fName)
+	if err != nil {
+		t.Fatalf("Failed to read file post-update: %v", err)
+	}
+	if !bytes.Equal(buf, oldFile) {
+		t.Fatalf("Target was modified by a failed update! Bytes read: %v, Bytes expected: %v", buf, oldFile)
+	}
 }
 
 func TestApplyPatch(t *testing.T) {
@@ -283,6 +595,198 @@ func TestVerifyRSASignature(t *testing.T) {
 	validateUpdate(fName, err, t)
 }
 
+func signversioned(privatePEM string, source []byte, version uint64, t *testing.T) []byte {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		t.Fatalf("Failed to parse private key PEM")
+	}
+	priv, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse private key DER: %v", err)
+	}
+
+	checksum := sha256.Sum256(source)
+	digest, err := VersionedChecksum(crypto.SHA256, checksum[:], version)
+	if err != nil {
+		t.Fatalf("Failed to compute versioned checksum: %v", err)
+	}
+	sig, err := priv.Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return sig
+}
+
+func TestVersionFloor(t *testing.T) {
+	fName := "TestVersionFloor"
+	defer cleanup(fName)
+	defer cleanup(".TestVersionFloor.version")
+	writeOldFile(fName, t)
+
+	apply := func(version uint64, sigVersion uint64, allowDowngrade bool) error {
+		opts := Options{
+			TargetPath:     fName,
+			Version:        version,
+			AllowDowngrade: allowDowngrade,
+			Signature:      signversioned(ecdsaPrivateKey, newFile, sigVersion, t),
+		}
+		if err := opts.SetPublicKeyPEM([]byte(ecdsaPublicKey)); err != nil {
+			t.Fatalf("Could not parse public key: %v", err)
+		}
+		return Apply(bytes.NewReader(newFile), opts)
+	}
+
+	validateUpdate(fName, apply(2, 2, false), t)
+	validateUpdate(fName, apply(2, 2, false), t)
+
+	if err := apply(3, 1, false); err == nil {
+		t.Fatalf("Accepted an update whose version wasn't signed!")
+	}
+	if _, ok := apply(1, 1, false).(*DowngradeError); !ok {
+		t.Fatalf("Accepted a downgrade!")
+	}
+	validateUpdate(fName, apply(1, 1, true), t)
+
+	// the floor never goes down
+	if _, ok := apply(1, 1, false).(*DowngradeError); !ok {
+		t.Fatalf("Accepted a downgrade after an explicit downgrade!")
+	}
+
+	// an unsigned version must not be able to raise the floor
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Checksum:   newFileChecksum[:],
+		Version:    1 << 62,
+	})
+	if err == nil {
+		t.Fatalf("Accepted an update with an unsigned version!")
+	}
+	validateUpdate(fName, apply(3, 3, false), t)
+}
+
+func TestVerifyEd25519Signature(t *testing.T) {
+	fName := "TestVerifyEd25519Signature"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	opts := Options{
+		TargetPath: fName,
+		Verifier:   NewEd25519Verifier(),
+	}
+	err = opts.SetPublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	opts.Signature = ed25519.Sign(priv, newFileChecksum[:])
+	err = Apply(bytes.NewReader(newFile), opts)
+	validateUpdate(fName, err, t)
+}
+
+func TestVerifyEd25519ShortKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signature := ed25519.Sign(priv, newFileChecksum[:])
+
+	for _, v := range []Verifier{NewEd25519Verifier(), NewAutoVerifier()} {
+		err := v.VerifySignature(newFileChecksum[:], signature, crypto.SHA256, pub[:ed25519.PublicKeySize-1])
+		if err == nil {
+			t.Fatalf("Verified a signature with a truncated Ed25519 key")
+		}
+	}
+}
+
+func signrsapss(privatePEM string, source []byte, t *testing.T) []byte {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		t.Fatalf("Failed to parse private key PEM")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse private key DER: %v", err)
+	}
+
+	checksum := sha256.Sum256(source)
+	sig, err := rsa.SignPSS(rand.Reader, priv, crypto.SHA256, checksum[:], nil)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return sig
+}
+
+func TestVerifyRSAPSSSignature(t *testing.T) {
+	fName := "TestVerifyRSAPSSSignature"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	opts := Options{
+		TargetPath: fName,
+		Verifier:   NewRSAPSSVerifier(nil),
+	}
+	err := opts.SetPublicKeyPEM([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	opts.Signature = signrsapss(rsaPrivateKey, newFile, t)
+	err = Apply(bytes.NewReader(newFile), opts)
+	validateUpdate(fName, err, t)
+}
+
+func TestVerifyRSASignatureSchemeMismatch(t *testing.T) {
+	fName := "TestVerifyRSASignatureSchemeMismatch"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	tests := []struct {
+		verifier  Verifier
+		signature []byte
+	}{
+		{NewRSAPSSVerifier(nil), signrsa(rsaPrivateKey, newFile, t)},
+		{NewRSAVerifier(), signrsapss(rsaPrivateKey, newFile, t)},
+	}
+	for i, tt := range tests {
+		opts := Options{
+			TargetPath: fName,
+			Verifier:   tt.verifier,
+			Signature:  tt.signature,
+		}
+		if err := opts.SetPublicKeyPEM([]byte(rsaPublicKey)); err != nil {
+			t.Fatalf("Could not parse public key: %v", err)
+		}
+		if err := Apply(bytes.NewReader(newFile), opts); err == nil {
+			t.Fatalf("Verified a signature made with the wrong RSA scheme in case %d!", i)
+		}
+	}
+}
+
+func TestVerifyRSASignatureAutoVerifier(t *testing.T) {
+	fName := "TestVerifyRSASignatureAutoVerifier"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	opts := Options{TargetPath: fName}
+	err := opts.SetPublicKeyPEM([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	opts.Signature = signrsa(rsaPrivateKey, newFile, t)
+	err = Apply(bytes.NewReader(newFile), opts)
+	validateUpdate(fName, err, t)
+}
+
 func TestVerifyFailBadSignature(t *testing.T) {
 	fName := "TestVerifyFailBadSignature"
 	defer cleanup(fName)
@@ -398,3 +902,54 @@ func TestWriteError(t *testing.T) {
 		t.Fatalf("Allowed an update to an empty file")
 	}
 }
+
+// stallingReader returns its first chunk and then blocks until it is closed.
+type stallingReader struct {
+	first  []byte
+	closed chan struct{}
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if len(r.first) > 0 {
+		n := copy(p, r.first)
+		r.first = r.first[n:]
+		return n, nil
+	}
+	<-r.closed
+	return 0, errors.New("read on closed reader")
+}
+
+func (r *stallingReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestApplyContextCancel(t *testing.T) {
+	fName := "TestApplyContextCancel"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	update := &stallingReader{first: newFile[:3], closed: make(chan struct{})}
+	err := ApplyContext(ctx, update, Options{TargetPath: fName})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected an error wrapping context.DeadlineExceeded, got: %v", err)
+	}
+
+	if _, err := os.Stat(fmt.Sprintf(".%s.new", fName)); !os.IsNotExist(err) {
+		t.Fatalf("Staged file was not removed after cancellation: %v", err)
+	}
+	if _, err := os.Stat(fmt.Sprintf(".%s.old", fName)); !os.IsNotExist(err) {
+		t.Fatalf("Old file was created by a cancelled update: %v", err)
+	}
+
+	buf, err := ioutil.ReadFile(fName)
+	if err != nil {
+		t.Fatalf("Failed to read file post-update: %v", err)
+	}
+	if !bytes.Equal(buf, oldFile) {
+		t.Fatalf("Target was modified by a cancelled update! Bytes read: %v, Bytes expected: %v", buf, oldFile)
+	}
+}
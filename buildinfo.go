@@ -0,0 +1,170 @@
+package update
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/inconshreveable/go-update/internal/semver"
+)
+
+// BuildInfo describes how a Go executable was built.
+type BuildInfo struct {
+	// Path is the path of the main module, e.g. "github.com/inconshreveable/ngrok".
+	Path string
+
+	// Version is the version of the main module, e.g. "v1.2.3", or "(devel)" if unknown.
+	Version string
+
+	// Revision is the version control revision the executable was built from, if recorded.
+	Revision string
+
+	// GoVersion is the version of the Go toolchain that built the executable.
+	GoVersion string
+}
+
+// ReadBuildInfo returns the build information embedded in the Go executable at path.
+func ReadBuildInfo(path string) (*BuildInfo, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bi := &BuildInfo{
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			bi.Revision = setting.Value
+		}
+	}
+	return bi, nil
+}
+
+// readBuildInfo is ReadBuildInfo, replaced in tests.
+var readBuildInfo = ReadBuildInfo
+
+// readInstalledBuildInfo returns the build information of the target at path, or nil if
+// there's none to enforce a policy against: the target doesn't exist or isn't a Go executable.
+// A target that looks like an executable but can't be read is an error.
+func readInstalledBuildInfo(path string) (*BuildInfo, error) {
+	bi, err := readBuildInfo(path)
+	if err == nil {
+		return bi, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	// debug/buildinfo doesn't export its error for executables without build info
+	if strings.HasSuffix(err.Error(), "not a Go executable") {
+		return nil, nil
+	}
+
+	// it reports I/O errors as an unrecognized format too, so tell them apart by looking
+	// at the file ourselves
+	exe, merr := hasExecutableMagic(path)
+	if merr != nil {
+		return nil, merr
+	}
+	if !exe {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// hasExecutableMagic reports whether the file at path starts like an ELF, PE or Mach-O executable.
+func hasExecutableMagic(path string) (bool, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fp.Close()
+
+	var magic [4]byte
+	if _, err := io.ReadFull(fp, magic[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if bytes.HasPrefix(magic[:], []byte("\x7fELF")) || bytes.HasPrefix(magic[:], []byte("MZ")) {
+		return true, nil
+	}
+	switch binary.BigEndian.Uint32(magic[:]) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat, 0xcefaedfe, 0xcffaedfe:
+		return true, nil
+	}
+	return false, nil
+}
+
+// BuildInfoPolicy selects the checks Apply performs on the build information of the
+// new executable and the one it replaces. Policies can be combined with |.
+type BuildInfoPolicy int
+
+const (
+	// RequireSameModule rejects an update whose main module path differs from that of the target.
+	RequireSameModule BuildInfoPolicy = 1 << iota
+
+	// RejectDowngrade rejects an update whose main module version is lower than that of the target.
+	// It has no effect unless both versions are valid semantic versions.
+	RejectDowngrade
+)
+
+// BuildInfoError is returned when an update is rejected because of the BuildInfoPolicy.
+type BuildInfoError struct {
+	// Installed is the build information of the target.
+	Installed *BuildInfo
+
+	// Update is the build information of the update, or nil if it has none.
+	Update *BuildInfo
+
+	// Err is the reason the update was rejected.
+	Err error
+}
+
+func (e *BuildInfoError) Error() string {
+	return fmt.Sprintf("update rejected by build info policy: %v", e.Err)
+}
+
+func (e *BuildInfoError) Unwrap() error {
+	return e.Err
+}
+
+// checkBuildInfo enforces the BuildInfoPolicy on the new file at path. Nothing is
+// enforced if the target doesn't exist or isn't a Go executable.
+func (o *Options) checkBuildInfo(path string) error {
+	if o.BuildInfoPolicy == 0 {
+		return nil
+	}
+	installed, err := readInstalledBuildInfo(o.TargetPath)
+	if err != nil {
+		return fmt.Errorf("failed to read build info of %s: %v", o.TargetPath, err)
+	}
+	if installed == nil {
+		// nothing installed to compare against
+		return nil
+	}
+
+	update, err := readBuildInfo(path)
+	if err != nil {
+		return &BuildInfoError{Installed: installed, Err: err}
+	}
+
+	if o.BuildInfoPolicy&RequireSameModule != 0 && update.Path != installed.Path {
+		return &BuildInfoError{installed, update, fmt.Errorf("module %s would replace module %s", update.Path, installed.Path)}
+	}
+	if o.BuildInfoPolicy&RejectDowngrade != 0 && semver.IsValid(installed.Version) && semver.IsValid(update.Version) {
+		if semver.Compare(update.Version, installed.Version) < 0 {
+			return &BuildInfoError{installed, update, fmt.Errorf("version %s would downgrade version %s", update.Version, installed.Version)}
+		}
+	}
+	return nil
+}
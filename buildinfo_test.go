@@ -0,0 +1,126 @@
+package update
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	bi, err := ReadBuildInfo(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to read build info of test executable: %v", err)
+	}
+	if bi.GoVersion != runtime.Version() {
+		t.Fatalf("Wrong Go version! Got: %s, expected: %s", bi.GoVersion, runtime.Version())
+	}
+}
+
+func TestBuildInfoPolicyNegative(t *testing.T) {
+	fName := "TestBuildInfoPolicyNegative"
+	defer cleanup(fName)
+
+	exe, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to read test executable: %v", err)
+	}
+	if err = ioutil.WriteFile(fName, exe, 0755); err != nil {
+		t.Fatalf("Failed to write file for testing preparation: %v", err)
+	}
+
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath:      fName,
+		BuildInfoPolicy: RequireSameModule | RejectDowngrade,
+	})
+	if _, ok := err.(*BuildInfoError); !ok {
+		t.Fatalf("Expected a *BuildInfoError, got: %v", err)
+	}
+}
+
+func TestBuildInfoPolicyNonGoTarget(t *testing.T) {
+	fName := "TestBuildInfoPolicyNonGoTarget"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	exe, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to read test executable: %v", err)
+	}
+
+	// a target that isn't a Go executable, e.g. a shell script wrapper, has nothing to enforce
+	err = Apply(bytes.NewReader(exe), Options{
+		TargetPath:      fName,
+		BuildInfoPolicy: RequireSameModule | RejectDowngrade,
+	})
+	if err != nil {
+		t.Fatalf("Failed to update a target that isn't a Go executable: %v", err)
+	}
+}
+
+func TestBuildInfoPolicyTruncatedTarget(t *testing.T) {
+	fName := "TestBuildInfoPolicyTruncatedTarget"
+	defer cleanup(fName)
+
+	// an executable that can't be read completely must not silently disable the policy
+	exe, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to read test executable: %v", err)
+	}
+	if err = ioutil.WriteFile(fName, exe[:4096], 0755); err != nil {
+		t.Fatalf("Failed to write file for testing preparation: %v", err)
+	}
+
+	err = Apply(bytes.NewReader(exe), Options{
+		TargetPath:      fName,
+		BuildInfoPolicy: RequireSameModule,
+	})
+	if err == nil {
+		t.Fatalf("Ignored the build info policy for an unreadable target")
+	}
+}
+
+func TestBuildInfoPolicy(t *testing.T) {
+	fName := "TestBuildInfoPolicy"
+	defer cleanup(fName)
+
+	installed := &BuildInfo{Path: "example.com/tool", Version: "v1.2.0"}
+	defer func() { readBuildInfo = ReadBuildInfo }()
+
+	tests := []struct {
+		name   string
+		update *BuildInfo
+		ok     bool
+	}{
+		{"Upgrade", &BuildInfo{Path: "example.com/tool", Version: "v1.3.0"}, true},
+		{"Devel", &BuildInfo{Path: "example.com/tool", Version: "(devel)"}, true},
+		{"OtherModule", &BuildInfo{Path: "example.com/other", Version: "v1.3.0"}, false},
+		{"Downgrade", &BuildInfo{Path: "example.com/tool", Version: "v1.1.9"}, false},
+	}
+	for _, tt := range tests {
+		writeOldFile(fName, t)
+		readBuildInfo = func(path string) (*BuildInfo, error) {
+			if path == fName {
+				return installed, nil
+			}
+			return tt.update, nil
+		}
+
+		err := Apply(bytes.NewReader(newFile), Options{
+			TargetPath:      fName,
+			BuildInfoPolicy: RequireSameModule | RejectDowngrade,
+		})
+		if tt.ok {
+			validateUpdate(fName, err, t)
+			continue
+		}
+		berr, ok := err.(*BuildInfoError)
+		if !ok {
+			t.Fatalf("%s: Expected a *BuildInfoError, got: %v", tt.name, err)
+		}
+		if berr.Installed != installed || berr.Update != tt.update {
+			t.Fatalf("%s: Wrong build info in error: %+v", tt.name, berr)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package check discovers new releases published as a signed update.ReleaseManifest.
+//
+// A Checker fetches manifest.json from a base URL, verifies its signature and compares
+// the version it announces with the version of the running program:
+//
+//	checker := &check.Checker{
+//		BaseURL:        "https://example.com/tool/stable/",
+//		PublicKey:      publicKey,
+//		CurrentVersion: version,
+//	}
+//	release, err := checker.Check()
+//	if err == check.ErrNoUpdate {
+//		return nil
+//	} else if err != nil {
+//		return err
+//	}
+//	return release.Apply(update.Options{})
+//
+// The manifest is expected to be a JSON update.SignedManifest. URLs of artifacts and
+// patches in it are resolved relative to the URL of the manifest.
+package check
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/inconshreveable/go-update"
+	"github.com/inconshreveable/go-update/internal/osext"
+	"github.com/inconshreveable/go-update/internal/semver"
+)
+
+// ErrNoUpdate is returned by Check when the running version is the latest release.
+var ErrNoUpdate = errors.New("no update available")
+
+// maximum size of a manifest, which is read into memory
+const maxManifestSize = 1 << 20
+
+// Checker checks for releases newer than the running program.
+type Checker struct {
+	// BaseURL is the URL of the directory that contains manifest.json.
+	BaseURL string
+
+	// PublicKey verifies the signature of the manifest and of the artifacts.
+	PublicKey crypto.PublicKey
+
+	// Hash and Verifier verify signatures like the fields of update.Options of the same name.
+	// If zero, they default to SHA256 and update.NewAutoVerifier.
+	Hash     crypto.Hash
+	Verifier update.Verifier
+
+	// CurrentVersion is the semantic version of the running program, e.g. "1.4.2".
+	CurrentVersion string
+
+	// HTTPClient makes the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// Release is a release newer than the running program.
+type Release struct {
+	// Version is the semantic version of the release.
+	Version string
+
+	// Manifest is the verified manifest of the release.
+	Manifest *update.ReleaseManifest
+
+	// Artifact is the artifact of the release for the running platform.
+	Artifact *update.Artifact
+
+	checker     *Checker
+	manifestURL *url.URL
+}
+
+// Check is shorthand for CheckContext with context.Background.
+func (c *Checker) Check() (*Release, error) {
+	return c.CheckContext(context.Background())
+}
+
+// CheckContext fetches and verifies the manifest and returns the release it describes if it's
+// newer than CurrentVersion according to semver rules. It returns ErrNoUpdate if it isn't.
+func (c *Checker) CheckContext(ctx context.Context) (*Release, error) {
+	if !semver.IsValid(c.CurrentVersion) {
+		return nil, fmt.Errorf("current version %q is not a semantic version", c.CurrentVersion)
+	}
+	if c.PublicKey == nil {
+		return nil, errors.New("no public key to verify the manifest with")
+	}
+
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	manifestURL := base.ResolveReference(&url.URL{Path: "manifest.json"})
+
+	body, err := c.get(ctx, manifestURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxManifestSize {
+		return nil, errors.New("manifest is too large")
+	}
+
+	var signed update.SignedManifest
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	m, err := signed.Verify(c.Hash, c.PublicKey, c.Verifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify manifest: %w", err)
+	}
+	if !semver.IsValid(m.Version) {
+		return nil, fmt.Errorf("release version %q is not a semantic version", m.Version)
+	}
+	if semver.Compare(m.Version, c.CurrentVersion) <= 0 {
+		return nil, ErrNoUpdate
+	}
+
+	a, err := m.PlatformArtifact()
+	if err != nil {
+		return nil, err
+	}
+	return &Release{
+		Version:     m.Version,
+		Manifest:    m,
+		Artifact:    a,
+		checker:     c,
+		manifestURL: manifestURL,
+	}, nil
+}
+
+// Apply is shorthand for ApplyContext with context.Background.
+func (r *Release) Apply(opts update.Options) error {
+	return r.ApplyContext(context.Background(), opts)
+}
+
+// ApplyContext downloads the release and applies it with update.ApplyContext. The Checksum,
+// Hash, Signature, PublicKey, Verifier and Patcher of opts are set from the release, everything
+// else is up to the caller. If the release has a patch from the file at opts.TargetPath, the
+// patch is downloaded instead of the whole artifact. The download must have exactly the size
+// the manifest gives for it, so a server can't fill up the disk with an endless response.
+func (r *Release) ApplyContext(ctx context.Context, opts update.Options) error {
+	if err := opts.SetArtifact(r.Artifact); err != nil {
+		return err
+	}
+	if opts.Signature != nil {
+		opts.PublicKey, opts.Verifier = r.checker.PublicKey, r.checker.Verifier
+	}
+	opts.Patcher = nil
+
+	target := opts.TargetPath
+	if target == "" {
+		var err error
+		if target, err = osext.Executable(); err != nil {
+			return err
+		}
+	}
+	ref, size := r.Artifact.URL, r.Artifact.Size
+	patch, err := r.Artifact.PatchFrom(target)
+	if err != nil {
+		return err
+	}
+	if patch != nil {
+		if err := opts.SetPatch(patch); err != nil {
+			return err
+		}
+		ref, size = patch.URL, patch.Size
+	}
+	if size <= 0 {
+		return fmt.Errorf("manifest doesn't give the size of %s", ref)
+	}
+
+	u, err := r.manifestURL.Parse(ref)
+	if err != nil {
+		return err
+	}
+	body, err := r.checker.get(ctx, u.String())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return update.ApplyContext(ctx, &sizeReader{r: io.LimitReader(body, size+1), size: size}, opts)
+}
+
+// sizeReader fails reads that go beyond size or end before it.
+type sizeReader struct {
+	r    io.Reader
+	size int64
+	n    int64
+}
+
+func (r *sizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n > r.size {
+		return n, fmt.Errorf("download is larger than its size of %d bytes", r.size)
+	}
+	if err == io.EOF && r.n < r.size {
+		return n, fmt.Errorf("download is %d bytes, expected %d", r.n, r.size)
+	}
+	return n, err
+}
+
+func (c *Checker) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
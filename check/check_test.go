@@ -0,0 +1,184 @@
+package check
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/inconshreveable/go-update"
+	"github.com/inconshreveable/go-update/internal/binarydist"
+)
+
+var (
+	oldFile = []byte{0xDE, 0xAD, 0xBE, 0xEF}
+	newFile = []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+)
+
+func sign(priv crypto.Signer, data []byte, t *testing.T) []byte {
+	checksum := sha256.Sum256(data)
+	sig, err := priv.Sign(rand.Reader, checksum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return sig
+}
+
+// serve publishes a release of newFile with a patch from oldFile and counts the requests per path.
+// The files it serves can be replaced through the returned map.
+func serve(priv crypto.Signer, version string, t *testing.T) (*httptest.Server, map[string]int, map[string][]byte) {
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader(oldFile), bytes.NewReader(newFile), &patch); err != nil {
+		t.Fatalf("Failed to create patch: %v", err)
+	}
+
+	m := update.ReleaseManifest{
+		Version: version,
+		Artifacts: map[string]*update.Artifact{
+			runtime.GOOS + "-" + runtime.GOARCH: {
+				URL:       "tool",
+				Size:      int64(len(newFile)),
+				Checksum:  fmt.Sprintf("%x", sha256.Sum256(newFile)),
+				Signature: sign(priv, newFile, t),
+				Patches: map[string]*update.Patch{
+					fmt.Sprintf("%x", sha256.Sum256(oldFile)): {URL: "patches/tool.bsdiff", Size: int64(patch.Len())},
+				},
+			},
+		},
+	}
+	canonical, err := m.MarshalCanonical()
+	if err != nil {
+		t.Fatalf("Failed to serialize manifest: %v", err)
+	}
+	manifest, err := json.Marshal(update.SignedManifest{Manifest: canonical, Signature: sign(priv, canonical, t)})
+	if err != nil {
+		t.Fatalf("Failed to serialize manifest: %v", err)
+	}
+
+	files := map[string][]byte{
+		"/stable/manifest.json":       manifest,
+		"/stable/tool":                newFile,
+		"/stable/patches/tool.bsdiff": patch.Bytes(),
+	}
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	return srv, requests, files
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return priv
+}
+
+func TestCheck(t *testing.T) {
+	priv := generateKey(t)
+	srv, requests, _ := serve(priv, "1.5.0", t)
+	defer srv.Close()
+
+	for _, current := range []string{"1.5.0", "v1.6", "1.5.1-rc.1"} {
+		checker := &Checker{BaseURL: srv.URL + "/stable", PublicKey: priv.Public(), CurrentVersion: current}
+		if _, err := checker.Check(); err != ErrNoUpdate {
+			t.Fatalf("Expected no update from %s, got: %v", current, err)
+		}
+	}
+
+	checker := &Checker{BaseURL: srv.URL + "/stable/", PublicKey: priv.Public(), CurrentVersion: "1.5.0-rc.1"}
+	release, err := checker.Check()
+	if err != nil {
+		t.Fatalf("Failed to check for update: %v", err)
+	}
+	if release.Version != "1.5.0" {
+		t.Fatalf("Expected release 1.5.0, got %s", release.Version)
+	}
+
+	fName := "TestCheck"
+	defer os.Remove(fName)
+	for _, patched := range []bool{false, true} {
+		contents := newFile
+		if patched {
+			contents = oldFile
+		}
+		if err := ioutil.WriteFile(fName, contents, 0777); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		if err := release.Apply(update.Options{TargetPath: fName}); err != nil {
+			t.Fatalf("Failed to apply release: %v", err)
+		}
+		buf, err := ioutil.ReadFile(fName)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		if !bytes.Equal(buf, newFile) {
+			t.Fatalf("File was not updated! Bytes read: %v", buf)
+		}
+	}
+	if requests["/stable/tool"] != 1 || requests["/stable/patches/tool.bsdiff"] != 1 {
+		t.Fatalf("Expected one download of the artifact and one of the patch, got %v", requests)
+	}
+}
+
+func TestCheckBadSignature(t *testing.T) {
+	srv, _, _ := serve(generateKey(t), "1.5.0", t)
+	defer srv.Close()
+
+	checker := &Checker{BaseURL: srv.URL + "/stable", PublicKey: generateKey(t).Public(), CurrentVersion: "1.4.2"}
+	if _, err := checker.Check(); err == nil || err == ErrNoUpdate {
+		t.Fatalf("Accepted a manifest signed with another key: %v", err)
+	}
+
+	checker = &Checker{BaseURL: srv.URL + "/beta", PublicKey: generateKey(t).Public(), CurrentVersion: "1.4.2"}
+	if _, err := checker.Check(); err == nil || err == ErrNoUpdate {
+		t.Fatalf("Expected an error for a missing manifest: %v", err)
+	}
+}
+
+func TestCheckArtifactSize(t *testing.T) {
+	priv := generateKey(t)
+	srv, _, files := serve(priv, "1.5.0", t)
+	defer srv.Close()
+
+	checker := &Checker{BaseURL: srv.URL + "/stable", PublicKey: priv.Public(), CurrentVersion: "1.4.2"}
+	release, err := checker.Check()
+	if err != nil {
+		t.Fatalf("Failed to check for update: %v", err)
+	}
+
+	fName := "TestCheckArtifactSize"
+	defer os.Remove(fName)
+	for _, served := range [][]byte{append(newFile, make([]byte, 1<<20)...), newFile[:3]} {
+		files["/stable/tool"] = served
+		if err := ioutil.WriteFile(fName, newFile[:1], 0777); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		err := release.Apply(update.Options{TargetPath: fName})
+		if err == nil || !strings.Contains(err.Error(), "bytes") {
+			t.Fatalf("Expected a size error for a download of %d bytes, got: %v", len(served), err)
+		}
+		if _, err := os.Stat(".TestCheckArtifactSize.new"); !os.IsNotExist(err) {
+			t.Fatalf("Rejected download was left behind: %v", err)
+		}
+	}
+}
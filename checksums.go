@@ -0,0 +1,177 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ChecksumManifest is a list of checksums of release artifacts, like a SHA256SUMS file.
+type ChecksumManifest struct {
+	Entries []ChecksumEntry
+}
+
+// ChecksumEntry is the checksum of a single artifact in a ChecksumManifest.
+type ChecksumEntry struct {
+	Name     string
+	Hash     crypto.Hash
+	Checksum []byte
+}
+
+// hash algorithms by their name in BSD-style checksum files
+var checksumHashes = map[string]crypto.Hash{
+	"SHA256":  crypto.SHA256,
+	"SHA384":  crypto.SHA384,
+	"SHA512":  crypto.SHA512,
+	"BLAKE2b": crypto.BLAKE2b_512,
+}
+
+// ParseChecksumManifest parses a checksum file in the format written by GNU coreutils
+// (sha256sum and friends, 'checksum  name' or 'checksum *name') or in the BSD format
+// written by sha256 -r and sha256sum --tag ('SHA256 (name) = checksum'). Lines may mix
+// both formats. The hash of GNU-style lines is inferred from the length of the checksum:
+// SHA256, SHA384 or SHA512. Blank lines and lines starting with # are ignored.
+func ParseChecksumManifest(data []byte) (*ChecksumManifest, error) {
+	m := &ChecksumManifest{}
+	seen := make(map[string]ChecksumEntry)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseChecksumLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("checksum manifest line %d: %v", n, err)
+		}
+
+		key := fmt.Sprintf("%d/%s", entry.Hash, entry.Name)
+		if prev, ok := seen[key]; ok {
+			if !bytes.Equal(prev.Checksum, entry.Checksum) {
+				return nil, fmt.Errorf("checksum manifest line %d: conflicting checksums for %s", n, entry.Name)
+			}
+			continue
+		}
+		seen[key] = entry
+		m.Entries = append(m.Entries, entry)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseChecksumLine(line string) (ChecksumEntry, error) {
+	var e ChecksumEntry
+
+	// names with a backslash or newline are escaped and the line is prefixed with a backslash
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	var sum string
+	if i := strings.Index(line, " ("); i > 0 && !strings.ContainsAny(line[:i], " *") {
+		// BSD: SHA256 (name) = checksum
+		j := strings.LastIndex(line, ") = ")
+		if j < i {
+			return e, errors.New("malformed BSD-style checksum")
+		}
+		hash, ok := checksumHashes[line[:i]]
+		if !ok {
+			return e, fmt.Errorf("unsupported hash algorithm %s", line[:i])
+		}
+		e.Hash, e.Name, sum = hash, line[i+2:j], line[j+4:]
+	} else {
+		// GNU: checksum  name or checksum *name
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+2 > len(line) || (line[i+1] != ' ' && line[i+1] != '*') {
+			return e, errors.New("malformed checksum")
+		}
+		sum, e.Name = line[:i], line[i+2:]
+		switch len(sum) {
+		case 2 * crypto.SHA256.Size():
+			e.Hash = crypto.SHA256
+		case 2 * crypto.SHA384.Size():
+			e.Hash = crypto.SHA384
+		case 2 * crypto.SHA512.Size():
+			e.Hash = crypto.SHA512
+		default:
+			return e, fmt.Errorf("unsupported checksum length %d", len(sum))
+		}
+	}
+
+	checksum, err := decodeChecksum(e.Hash, sum)
+	if err != nil {
+		return e, err
+	}
+	e.Checksum = checksum
+
+	if escaped {
+		e.Name = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(e.Name)
+	}
+	if e.Name == "" {
+		return e, errors.New("missing file name")
+	}
+	return e, nil
+}
+
+// Lookup returns the entry for the artifact with the given name. A leading ./ is ignored
+// on both sides. If the manifest lists the artifact with several hashes, the first one wins.
+func (m *ChecksumManifest) Lookup(name string) (*ChecksumEntry, error) {
+	name = strings.TrimPrefix(name, "./")
+	for i, e := range m.Entries {
+		if strings.TrimPrefix(e.Name, "./") == name {
+			return &m.Entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no checksum for %s in manifest", name)
+}
+
+// VerifyChecksumManifest verifies the detached signature of a checksum file and parses it.
+// The signature is checked the same way Apply checks the signature of an update: with
+// verifier, which defaults to NewAutoVerifier, over the checksum of data computed with
+// hash, which defaults to SHA256. A PayloadVerifier gets to see data itself instead.
+func VerifyChecksumManifest(data, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey, verifier Verifier) (*ChecksumManifest, error) {
+	if err := verifyDetached(data, signature, hash, publicKey, verifier); err != nil {
+		return nil, err
+	}
+	return ParseChecksumManifest(data)
+}
+
+// verifyDetached verifies a signature of data with the defaults of Options.
+func verifyDetached(data, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey, verifier Verifier) error {
+	if signature == nil || publicKey == nil {
+		return errors.New("no signature or public key to verify with")
+	}
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	if verifier == nil {
+		verifier = NewAutoVerifier()
+	}
+	if pv, ok := verifier.(PayloadVerifier); ok {
+		return pv.VerifyPayload(bytes.NewReader(data), signature, publicKey)
+	}
+	if !hash.Available() {
+		return errors.New("requested hash function not available")
+	}
+	h := hash.New()
+	h.Write(data)
+	return verifier.VerifySignature(h.Sum(nil), signature, hash, publicKey)
+}
+
+// SetChecksumManifest is a convenience method to set the Checksum and Hash properties
+// to those of the artifact with the given name in a checksum manifest.
+func (o *Options) SetChecksumManifest(m *ChecksumManifest, name string) error {
+	e, err := m.Lookup(name)
+	if err != nil {
+		return err
+	}
+	o.Checksum = e.Checksum
+	o.Hash = e.Hash
+	return nil
+}
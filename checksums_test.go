@@ -0,0 +1,104 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func checksumManifest() []byte {
+	sum512 := sha512.Sum512(newFile)
+	return []byte(fmt.Sprintf(`%x  tool_linux_amd64
+%x *tool_windows_amd64.exe
+SHA512 (tool_darwin_arm64) = %x
+\%x  tool\\odd
+`, newFileChecksum, newFileChecksum, sum512, newFileChecksum))
+}
+
+func TestParseChecksumManifest(t *testing.T) {
+	m, err := ParseChecksumManifest(checksumManifest())
+	if err != nil {
+		t.Fatalf("Failed to parse checksum manifest: %v", err)
+	}
+	if len(m.Entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(m.Entries))
+	}
+
+	for name, hash := range map[string]crypto.Hash{
+		"tool_linux_amd64":       crypto.SHA256,
+		"tool_windows_amd64.exe": crypto.SHA256,
+		"./tool_darwin_arm64":    crypto.SHA512,
+		`tool\odd`:               crypto.SHA256,
+	} {
+		e, err := m.Lookup(name)
+		if err != nil {
+			t.Fatalf("Failed to look up %s: %v", name, err)
+		}
+		if e.Hash != hash {
+			t.Fatalf("Expected %v checksum for %s, got %v", hash, name, e.Hash)
+		}
+	}
+
+	if _, err := m.Lookup("tool_plan9_386"); err == nil {
+		t.Fatalf("Found a checksum for an artifact not in the manifest")
+	}
+
+	for _, bad := range []string{
+		"abcd  tool",
+		hex.EncodeToString(newFileChecksum[:]) + " tool",
+		"MD5 (tool) = d41d8cd98f00b204e9800998ecf8427e",
+		fmt.Sprintf("%x  tool\n%x  tool", newFileChecksum, sha512.Sum512_256(oldFile)),
+	} {
+		if _, err := ParseChecksumManifest([]byte(bad)); err == nil {
+			t.Fatalf("Accepted malformed checksum manifest %q", bad)
+		}
+	}
+}
+
+func TestApplyChecksumManifest(t *testing.T) {
+	fName := "TestApplyChecksumManifest"
+	defer cleanup(fName)
+
+	manifest := checksumManifest()
+	opts := Options{}
+	err := opts.SetPublicKeyPEM([]byte(ecdsaPublicKey))
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	m, err := VerifyChecksumManifest(manifest, signec(ecdsaPrivateKey, manifest, t), 0, opts.PublicKey, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify checksum manifest: %v", err)
+	}
+
+	for _, name := range []string{"tool_linux_amd64", "tool_darwin_arm64"} {
+		writeOldFile(fName, t)
+		opts := Options{TargetPath: fName}
+		if err := opts.SetChecksumManifest(m, name); err != nil {
+			t.Fatalf("Failed to set checksum: %v", err)
+		}
+		err = Apply(bytes.NewReader(newFile), opts)
+		validateUpdate(fName, err, t)
+	}
+
+	tampered := bytes.Replace(manifest, []byte("linux"), []byte("Linux"), 1)
+	_, err = VerifyChecksumManifest(tampered, signec(ecdsaPrivateKey, manifest, t), 0, opts.PublicKey, nil)
+	if err == nil {
+		t.Fatalf("Accepted a tampered checksum manifest!")
+	}
+
+	// signify signs the manifest itself rather than its checksum
+	pubFile, priv := generateMinisignKey(t)
+	pub, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+	sig := "untrusted comment: verify with release.pub\n" + minisignLine("Ed", ed25519.Sign(priv, manifest)) + "\n"
+	if _, err = VerifyChecksumManifest(manifest, []byte(sig), 0, pub, NewSignifyVerifier()); err != nil {
+		t.Fatalf("Failed to verify signify signature of checksum manifest: %v", err)
+	}
+}
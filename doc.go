@@ -136,7 +136,7 @@ with the private key and distribute the signature along with the update.
 			Checksum: checksum,
 			Signature: signature,
 			Hash: crypto.SHA256, 	                 // this is the default, you don't need to specify it
-			Verifier: update.NewECDSAVerifier(),   // this is the default, you don't need to specify it
+			Verifier: update.NewECDSAVerifier(),   // the default picks this from the type of the public key
 		}
 		err = opts.SetPublicKeyPEM(publicKey)
 		if err != nil {
@@ -160,7 +160,8 @@ the distributed binary with a tool like go-bindata (my favorite): https://github
 Non-Goals
 
 Mechanisms and protocols for determining whether an update should be applied and, if so, which one are
-out of scope for this package. Please consult go-tuf (https://github.com/flynn/go-tuf) or Equinox (https://equinox.io)
+out of scope for this package. The optional check subpackage covers the simple case of polling a signed
+ReleaseManifest; please consult go-tuf (https://github.com/flynn/go-tuf) or Equinox (https://equinox.io)
 for more complete solutions.
 
 go-update only works for self-updating applications that are distributed as a single binary, i.e.
@@ -0,0 +1,345 @@
+// Package download fetches updates over unreliable connections before applying them.
+//
+// A Downloader keeps what it has received so far in a partial file next to the target,
+// so a download that is interrupted resumes where it left off the next time it's run:
+//
+//	d := &download.Downloader{URL: "https://example.com/tool/1.5.0/tool_linux_amd64", Size: 8123456}
+//	err := d.Apply(update.Options{Checksum: checksum})
+//
+// Resuming uses HTTP Range requests validated with If-Range, so a file that changed on
+// the server in the meantime is downloaded from scratch rather than spliced together.
+//
+// Builds published to several mirrors can be given as Downloader.Mirrors. Since the update
+// is verified before and by update.Apply, a broken or malicious mirror can only make the
+// Downloader move on to the next one. A download cut off on one mirror is only resumed from
+// another one if it serves the file with the same strong ETag, as mirrors that share a CDN or
+// derive their ETags from the content do. Otherwise falling back starts the download over.
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/inconshreveable/go-update"
+	"github.com/inconshreveable/go-update/internal/osext"
+)
+
+// Downloader downloads an update to disk, verifies it and applies it.
+type Downloader struct {
+	// URL of the update.
+	URL string
+
+	// Mirrors are URLs of the same update to fall back to, in order, if URL fails.
+	Mirrors []string
+
+	// Expected size of the update. If zero, the size is not checked.
+	Size int64
+
+	// Expected checksum of the downloaded file, computed with Hash. If nil, the Checksum
+	// and Hash of the update.Options are used unless they describe the result of a patch.
+	Checksum []byte
+
+	// Hash function of Checksum. If zero, defaults to SHA256.
+	Hash crypto.Hash
+
+	// HTTPClient makes the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	servedBy string
+}
+
+// metadata is stored next to a partial download to validate resuming it.
+type metadata struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// SizeError is returned when the downloaded file doesn't have the expected size.
+type SizeError struct {
+	Want, Got int64
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("downloaded %d bytes, expected %d", e.Got, e.Want)
+}
+
+// MirrorError is returned when none of the URLs of a Downloader served a valid update.
+type MirrorError struct {
+	URLs []string // the URLs tried, in order
+	Errs []error  // the error of each URL
+}
+
+func (e *MirrorError) Error() string {
+	msgs := make([]string, len(e.URLs))
+	for i, url := range e.URLs {
+		msgs[i] = fmt.Sprintf("%s: %v", url, e.Errs[i])
+	}
+	return "all mirrors failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *MirrorError) Unwrap() []error {
+	return e.Errs
+}
+
+// Apply is shorthand for ApplyContext with context.Background.
+func (d *Downloader) Apply(opts update.Options) error {
+	return d.ApplyContext(context.Background(), opts)
+}
+
+// ApplyContext downloads the update to /path/to/.target.partial, resuming a previous download
+// if there is one, checks its size and checksum and then applies it with update.ApplyContext.
+// The partial file and its metadata in /path/to/.target.partial.json are removed once the update
+// is applied, if the downloaded file turns out to be corrupt or if update.ApplyContext rejects it
+// for any reason other than ctx being done. If the download fails, they are kept and calling
+// ApplyContext again resumes it.
+//
+// If the update from URL can't be downloaded because of a network error or an HTTP error status,
+// doesn't have the expected size or checksum, or is rejected by update.ApplyContext with a
+// *update.VerificationError, *update.ExecutableError or *update.BuildInfoError, the Mirrors are
+// tried in order. A previous download is resumed from the URL it was started from before any of
+// the others are tried, and from the others only if they serve it with the same strong ETag. Other
+// errors of update.ApplyContext don't cause a fallback. If every URL fails, the error is a
+// *MirrorError, unless there are no Mirrors.
+func (d *Downloader) ApplyContext(ctx context.Context, opts update.Options) error {
+	target := opts.TargetPath
+	if target == "" {
+		var err error
+		if target, err = osext.Executable(); err != nil {
+			return err
+		}
+	}
+	partialPath := filepath.Join(filepath.Dir(target), fmt.Sprintf(".%s.partial", filepath.Base(target)))
+
+	urls := append([]string{d.URL}, d.Mirrors...)
+
+	// resume from the URL the partial file came from first
+	var meta metadata
+	if buf, err := ioutil.ReadFile(partialPath + ".json"); err == nil && json.Unmarshal(buf, &meta) == nil {
+		for i, url := range urls {
+			if url == meta.URL {
+				urls = append(append([]string{url}, urls[:i]...), urls[i+1:]...)
+				break
+			}
+		}
+	}
+
+	merr := &MirrorError{}
+	for _, url := range urls {
+		fallback, err := d.apply(ctx, partialPath, url, opts)
+		if err == nil {
+			d.servedBy = url
+			return nil
+		}
+		if !fallback || ctx.Err() != nil || len(d.Mirrors) == 0 {
+			return err
+		}
+		merr.URLs = append(merr.URLs, url)
+		merr.Errs = append(merr.Errs, err)
+	}
+	return merr
+}
+
+// ServedBy returns the URL that served the update the last time ApplyContext applied it successfully.
+func (d *Downloader) ServedBy() string {
+	return d.servedBy
+}
+
+// apply downloads the update from url to path, verifies it and applies it. It reports
+// whether the failure may be the fault of url, so another mirror may succeed.
+func (d *Downloader) apply(ctx context.Context, path, url string, opts update.Options) (bool, error) {
+	if err := d.download(ctx, path, url); err != nil {
+		return true, err
+	}
+	if err := d.verify(path, opts); err != nil {
+		removePartial(path)
+		return true, err
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	err = update.ApplyContext(ctx, fp, opts)
+	fp.Close()
+	if err != nil && ctx.Err() != nil {
+		// the download is fine, it's just not applied yet
+		return false, err
+	}
+	removePartial(path)
+	return rejected(err), err
+}
+
+// rejected reports whether update.ApplyContext failed because of the contents of the update.
+func rejected(err error) bool {
+	var verr *update.VerificationError
+	var eerr *update.ExecutableError
+	var berr *update.BuildInfoError
+	return errors.As(err, &verr) || errors.As(err, &eerr) || errors.As(err, &berr)
+}
+
+// download completes the partial file at path from url.
+func (d *Downloader) download(ctx context.Context, path, url string) error {
+	var offset int64
+	var meta metadata
+	if buf, err := ioutil.ReadFile(path + ".json"); err == nil && json.Unmarshal(buf, &meta) == nil {
+		if fi, err := os.Stat(path); err == nil {
+			offset = fi.Size()
+		}
+	}
+	if d.Size > 0 && offset == d.Size && meta.URL == url {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// only a strong ETag or a Last-Modified date can validate a range, and only
+	// an ETag can tell that another mirror serves the same file
+	var validator string
+	if meta.ETag != "" && !strings.HasPrefix(meta.ETag, "W/") {
+		validator = meta.ETag
+	} else if meta.URL == url {
+		validator = meta.LastModified
+	}
+	if offset > 0 && validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	} else {
+		offset = 0
+	}
+
+	client := d.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, err := rangeStart(resp.Header.Get("Content-Range")); err != nil || start != offset {
+			return fmt.Errorf("GET %s: unexpected Content-Range %q", url, resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+		if meta.URL != url {
+			// resume from this mirror the next time
+			meta.URL = url
+			if err := writeMetadata(path, &meta); err != nil {
+				return err
+			}
+		}
+	case http.StatusOK:
+		// no partial file, or it's stale
+		flags |= os.O_TRUNC
+		offset = 0
+		meta = metadata{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+		if err := writeMetadata(path, &meta); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file may be complete already if the size wasn't known
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	default:
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	fp, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	body := io.Reader(resp.Body)
+	if d.Size > 0 {
+		// one more byte than expected is enough to tell the download is too large
+		body = io.LimitReader(body, d.Size-offset+1)
+	}
+	_, err = io.Copy(fp, body)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// verify checks the size and checksum of the completed download at path.
+func (d *Downloader) verify(path string, opts update.Options) error {
+	checksum, hash := d.Checksum, d.Hash
+	if checksum == nil && opts.Patcher == nil {
+		checksum, hash = opts.Checksum, opts.Hash
+	}
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if d.Size > 0 {
+		fi, err := fp.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() != d.Size {
+			return &SizeError{Want: d.Size, Got: fi.Size()}
+		}
+	}
+	if checksum == nil {
+		return nil
+	}
+
+	if !hash.Available() {
+		return errors.New("requested hash function not available")
+	}
+	h := hash.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return err
+	}
+	if !bytes.Equal(checksum, h.Sum(nil)) {
+		return fmt.Errorf("Downloaded file has wrong checksum. Expected: %x, got: %x", checksum, h.Sum(nil))
+	}
+	return nil
+}
+
+// rangeStart returns the first byte position of a Content-Range header like "bytes 100-199/200".
+func rangeStart(contentRange string) (int64, error) {
+	spec := strings.TrimPrefix(contentRange, "bytes ")
+	i := strings.IndexByte(spec, '-')
+	if spec == contentRange || i < 0 {
+		return 0, errors.New("malformed Content-Range")
+	}
+	return strconv.ParseInt(spec[:i], 10, 64)
+}
+
+func writeMetadata(path string, meta *metadata) error {
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".json", buf, 0644)
+}
+
+func removePartial(path string) {
+	os.Remove(path)
+	os.Remove(path + ".json")
+}
@@ -0,0 +1,324 @@
+package download
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/go-update"
+)
+
+const partialPath = ".TestDownload.partial"
+
+// server serves content, cutting off the connection halfway through the next response if cut is set.
+type server struct {
+	content  []byte
+	etag     string
+	cut      bool
+	requests []*http.Request
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests = append(s.requests, r)
+	w.Header().Set("ETag", s.etag)
+	if s.cut {
+		s.cut = false
+		w.Header().Set("Content-Length", "1048576")
+		w.Write(s.content[:len(s.content)/2])
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "update", time.Time{}, bytes.NewReader(s.content))
+}
+
+func newContent(t *testing.T) []byte {
+	content := make([]byte, 64*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("Failed to generate update: %v", err)
+	}
+	return content
+}
+
+func cleanup(fName string) {
+	os.Remove(fName)
+	os.Remove(partialPath)
+	os.Remove(partialPath + ".json")
+}
+
+func writeOldFile(fName string, t *testing.T) {
+	if err := ioutil.WriteFile(fName, []byte{0xDE, 0xAD, 0xBE, 0xEF}, 0777); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+func validateUpdate(fName string, content []byte, err error, t *testing.T) {
+	if err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	buf, err := ioutil.ReadFile(fName)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(buf, content) {
+		t.Fatalf("File was not updated!")
+	}
+	if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+		t.Fatalf("Partial download was not removed: %v", err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	s := &server{content: newContent(t), etag: `"v1"`, cut: true}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	checksum := sha256.Sum256(s.content)
+	d := &Downloader{URL: srv.URL + "/tool", Size: int64(len(s.content))}
+	if err := d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]}); err == nil {
+		t.Fatalf("Expected the cut off download to fail")
+	}
+	fi, err := os.Stat(partialPath)
+	if err != nil || fi.Size() != int64(len(s.content)/2) {
+		t.Fatalf("Expected half of the update in the partial file: %v", err)
+	}
+
+	err = d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]})
+	validateUpdate(fName, s.content, err, t)
+
+	r := s.requests[1]
+	if r.Header.Get("Range") != "bytes=32768-" || r.Header.Get("If-Range") != `"v1"` {
+		t.Fatalf("Download was not resumed: Range %q, If-Range %q", r.Header.Get("Range"), r.Header.Get("If-Range"))
+	}
+}
+
+func TestDownloadChanged(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	s := &server{content: newContent(t), etag: `"v1"`, cut: true}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	d := &Downloader{URL: srv.URL + "/tool"}
+	if err := d.Apply(update.Options{TargetPath: fName}); err == nil {
+		t.Fatalf("Expected the cut off download to fail")
+	}
+
+	// the file changed on the server, so resuming would splice together two files
+	s.content, s.etag = newContent(t), `"v2"`
+	checksum := sha256.Sum256(s.content)
+	d.Checksum = checksum[:]
+	err := d.Apply(update.Options{TargetPath: fName})
+	validateUpdate(fName, s.content, err, t)
+}
+
+func TestDownloadCorrupt(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	s := &server{content: newContent(t), etag: `"v1"`}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	checksum := sha256.Sum256([]byte("something else"))
+	d := &Downloader{URL: srv.URL + "/tool"}
+	if err := d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]}); err == nil {
+		t.Fatalf("Accepted a download with the wrong checksum!")
+	}
+	if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+		t.Fatalf("Corrupt download was not removed: %v", err)
+	}
+
+	d = &Downloader{URL: srv.URL + "/tool", Size: int64(len(s.content)) - 1}
+	err := d.Apply(update.Options{TargetPath: fName})
+	if _, ok := err.(*SizeError); !ok {
+		t.Fatalf("Expected a *SizeError, got: %v", err)
+	}
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	content := newContent(t)
+	good := httptest.NewServer(&server{content: content, etag: `"v1"`})
+	defer good.Close()
+	corrupt := httptest.NewServer(&server{content: newContent(t), etag: `"v1"`})
+	defer corrupt.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	checksum := sha256.Sum256(content)
+	d := &Downloader{
+		URL:     down.URL + "/tool",
+		Mirrors: []string{missing.URL + "/tool", corrupt.URL + "/tool", good.URL + "/tool"},
+	}
+	err := d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]})
+	validateUpdate(fName, content, err, t)
+	if d.ServedBy() != good.URL+"/tool" {
+		t.Fatalf("Expected the update to be served by %s, got %s", good.URL, d.ServedBy())
+	}
+
+	d.Mirrors = d.Mirrors[:2]
+	err = d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]})
+	merr, ok := err.(*MirrorError)
+	if !ok || len(merr.URLs) != 3 {
+		t.Fatalf("Expected a *MirrorError for all 3 URLs, got: %v", err)
+	}
+}
+
+func TestDownloadMirrorsResume(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	content := newContent(t)
+	primary := &server{content: content, etag: `"v1"`}
+	psrv := httptest.NewServer(primary)
+	defer psrv.Close()
+	mirror := &server{content: content, etag: `"v1"`, cut: true}
+	msrv := httptest.NewServer(mirror)
+	defer msrv.Close()
+
+	// the primary is down at first, and the download from the mirror is cut off
+	checksum := sha256.Sum256(content)
+	d := &Downloader{URL: psrv.URL + "/tool", Mirrors: []string{msrv.URL + "/tool"}}
+	primary.cut = true
+	if err := d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]}); err == nil {
+		t.Fatalf("Expected the cut off downloads to fail")
+	}
+
+	// the download is resumed from the mirror it was started from
+	mirror.requests, primary.requests = nil, nil
+	err := d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]})
+	validateUpdate(fName, content, err, t)
+	if len(primary.requests) != 0 || len(mirror.requests) != 1 || mirror.requests[0].Header.Get("Range") == "" {
+		t.Fatalf("Download was not resumed from the mirror")
+	}
+	if d.ServedBy() != msrv.URL+"/tool" {
+		t.Fatalf("Expected the update to be served by %s, got %s", msrv.URL, d.ServedBy())
+	}
+}
+
+func TestDownloadMirrorsResumeOther(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	content := newContent(t)
+	primary := &server{content: content, etag: `"v1"`, cut: true}
+	psrv := httptest.NewServer(primary)
+	defer psrv.Close()
+	mirror := &server{content: content, etag: `"v1"`}
+	msrv := httptest.NewServer(mirror)
+	defer msrv.Close()
+
+	// the mirror serves the same file as the primary, so it can complete its download
+	checksum := sha256.Sum256(content)
+	d := &Downloader{URL: psrv.URL + "/tool", Mirrors: []string{msrv.URL + "/tool"}}
+	err := d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]})
+	validateUpdate(fName, content, err, t)
+	r := mirror.requests[0]
+	if r.Header.Get("Range") != "bytes=32768-" || r.Header.Get("If-Range") != `"v1"` {
+		t.Fatalf("Download was not resumed from the mirror: Range %q, If-Range %q", r.Header.Get("Range"), r.Header.Get("If-Range"))
+	}
+
+	// a mirror with another ETag may serve another file, so the download starts over
+	writeOldFile(fName, t)
+	primary.cut, mirror.etag, mirror.requests = true, `"other"`, nil
+	err = d.Apply(update.Options{TargetPath: fName, Checksum: checksum[:]})
+	validateUpdate(fName, content, err, t)
+	if len(mirror.requests) != 1 {
+		t.Fatalf("Expected one request to the mirror, got %d", len(mirror.requests))
+	}
+}
+
+func TestDownloadRejected(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	content := newContent(t)
+	checksum := sha256.Sum256(content)
+	opts := update.Options{TargetPath: fName, PublicKey: pub, Signature: ed25519.Sign(priv, checksum[:])}
+
+	// only Apply can tell the download is bad, from its signature
+	s := &server{content: newContent(t), etag: `"v1"`}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	d := &Downloader{URL: srv.URL + "/tool", Size: int64(len(content))}
+	if err := d.Apply(opts); err == nil {
+		t.Fatalf("Accepted a download with a bad signature!")
+	}
+	if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+		t.Fatalf("Rejected download was not removed: %v", err)
+	}
+
+	s.content, s.etag = content, `"v2"`
+	err = d.Apply(opts)
+	validateUpdate(fName, content, err, t)
+}
+
+func TestDownloadMirrorsSignatureOnly(t *testing.T) {
+	fName := "TestDownload"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	content := newContent(t)
+	checksum := sha256.Sum256(content)
+	opts := update.Options{TargetPath: fName, PublicKey: pub, Signature: ed25519.Sign(priv, checksum[:])}
+
+	// the only thing wrong with the bad mirror's file is its signature
+	bad := &server{content: newContent(t), etag: `"v1"`}
+	bsrv := httptest.NewServer(bad)
+	defer bsrv.Close()
+	good := httptest.NewServer(&server{content: content, etag: `"v1"`})
+	defer good.Close()
+
+	d := &Downloader{URL: bsrv.URL + "/tool", Mirrors: []string{good.URL + "/tool"}, Size: int64(len(content))}
+	err = d.Apply(opts)
+	validateUpdate(fName, content, err, t)
+	if len(bad.requests) != 1 {
+		t.Fatalf("Expected the bad mirror to be tried first")
+	}
+	if d.ServedBy() != good.URL+"/tool" {
+		t.Fatalf("Expected the update to be served by %s, got %s", good.URL, d.ServedBy())
+	}
+
+	// with only bad mirrors, every one is tried and reported
+	writeOldFile(fName, t)
+	d = &Downloader{URL: bsrv.URL + "/tool", Mirrors: []string{bsrv.URL + "/mirror"}, Size: int64(len(content))}
+	err = d.Apply(opts)
+	merr, ok := err.(*MirrorError)
+	if !ok || len(merr.URLs) != 2 {
+		t.Fatalf("Expected a *MirrorError for both URLs, got: %v", err)
+	}
+	var verr *update.VerificationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *update.VerificationError in the *MirrorError, got: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// maxHealthCheckOutput limits how much output of a health check is kept.
+const maxHealthCheckOutput = 64 * 1024
+
+// HealthCheck describes how to check that a freshly installed executable is able to run.
+type HealthCheck struct {
+	// Args are the arguments to run the new executable with, e.g. []string{"--self-test"}.
+	Args []string
+
+	// Timeout is how long the new executable may run before the check fails.
+	// If zero, defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// HealthCheckError is returned when the health check of a freshly installed executable
+// failed. The previous executable was restored unless RollbackErr is set.
+type HealthCheckError struct {
+	// Err is the reason the health check failed: an *exec.ExitError if the executable exited
+	// with a non-zero status or was killed by a signal, context.DeadlineExceeded if it timed out,
+	// or the error that prevented it from starting at all.
+	Err error
+
+	// TimedOut reports whether the executable was killed because it ran longer than the Timeout.
+	TimedOut bool
+
+	// Output holds the combined standard output and standard error of the executable,
+	// truncated to its first 64 KiB.
+	Output []byte
+
+	// RollbackErr is the error encountered while restoring the previous executable,
+	// or nil if it was restored successfully.
+	RollbackErr error
+}
+
+func (e *HealthCheckError) Error() string {
+	msg := fmt.Sprintf("health check of new executable failed: %v", e.Err)
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%s; failed to restore previous executable: %v", msg, e.RollbackErr)
+	}
+	return msg + "; previous executable restored"
+}
+
+func (e *HealthCheckError) Unwrap() error {
+	return e.Err
+}
+
+// checkHealth runs the configured health check, if any, against path. If the check fails,
+// it calls restore to bring back the previous executable.
+func (o *Options) checkHealth(path string, restore func() error) error {
+	if o.HealthCheck == nil {
+		return nil
+	}
+	herr := o.HealthCheck.run(path)
+	if herr == nil {
+		return nil
+	}
+	herr.RollbackErr = restore()
+	return herr
+}
+
+func (hc *HealthCheck) run(path string) *HealthCheckError {
+	timeout := hc.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// never resolve a relative path through $PATH
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return &HealthCheckError{Err: err}
+	}
+
+	output := &limitedBuffer{max: maxHealthCheckOutput}
+	cmd := exec.CommandContext(ctx, path, hc.Args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// don't wait forever on children that inherited the output pipes
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	herr := &HealthCheckError{Err: err, Output: output.Bytes()}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		herr.Err = ctx.Err()
+		herr.TimedOut = true
+	}
+	return herr
+}
+
+// limitedBuffer keeps the first max bytes written to it and silently discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
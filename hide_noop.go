@@ -5,3 +5,7 @@ package update
 func hideFile(path string) error {
 	return nil
 }
+
+func unhideFile(path string) error {
+	return nil
+}
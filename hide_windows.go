@@ -6,10 +6,18 @@ import (
 )
 
 func hideFile(path string) error {
+	return setFileAttributes(path, syscall.FILE_ATTRIBUTE_HIDDEN)
+}
+
+func unhideFile(path string) error {
+	return setFileAttributes(path, syscall.FILE_ATTRIBUTE_NORMAL)
+}
+
+func setFileAttributes(path string, attrs uintptr) error {
 	kernel32 := syscall.NewLazyDLL("kernel32.dll")
-	setFileAttributes := kernel32.NewProc("SetFileAttributesW")
+	proc := kernel32.NewProc("SetFileAttributesW")
 
-	r1, _, err := setFileAttributes.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(path))), 2)
+	r1, _, err := proc.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(path))), attrs)
 
 	if r1 == 0 {
 		return err
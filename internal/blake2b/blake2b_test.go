@@ -0,0 +1,46 @@
+package blake2b
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum512(t *testing.T) {
+	long := bytes.Repeat(func() []byte {
+		b := make([]byte, 256)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		return b
+	}(), 3)
+
+	tests := []struct {
+		data []byte
+		hex  string
+	}{
+		{nil, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{[]byte("abc"), "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{long, "323e97a7a859ee63c9013debb0ca995811e73117a2f574723416e596ebc184e37a59b66d2f597df4a7c1b0d1d41a1a7f28774f46a6864d56c57b9d6c5f7302fb"},
+	}
+	for _, tt := range tests {
+		sum := Sum512(tt.data)
+		if got := hex.EncodeToString(sum[:]); got != tt.hex {
+			t.Errorf("Sum512(%d bytes) = %s, want %s", len(tt.data), got, tt.hex)
+		}
+
+		// write in uneven pieces to exercise buffering across block boundaries
+		h := New512()
+		for data := tt.data; len(data) > 0; {
+			n := 7
+			if n > len(data) {
+				n = len(data)
+			}
+			h.Write(data[:n])
+			data = data[n:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != tt.hex {
+			t.Errorf("streaming Sum512(%d bytes) = %s, want %s", len(tt.data), got, tt.hex)
+		}
+	}
+}
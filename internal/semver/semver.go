@@ -0,0 +1,161 @@
+// Package semver compares version strings according to Semantic Versioning 2.0.0
+// (https://semver.org). A leading "v", as in Go module versions, is optional, and
+// like in Go module versions, "v1" and "v1.2" are shorthands for "v1.0.0" and "v1.2.0".
+package semver
+
+import "strings"
+
+type version struct {
+	major, minor, patch string
+	prerelease          []string
+}
+
+// IsValid reports whether v is a valid semantic version.
+func IsValid(v string) bool {
+	_, ok := parse(v)
+	return ok
+}
+
+// Compare returns -1, 0 or +1 depending on whether v < w, v == w or v > w.
+// Build metadata is ignored. An invalid version is considered less than any valid one,
+// and equal to any other invalid one.
+func Compare(v, w string) int {
+	pv, okv := parse(v)
+	pw, okw := parse(w)
+	switch {
+	case !okv && !okw:
+		return 0
+	case !okv:
+		return -1
+	case !okw:
+		return 1
+	}
+
+	if c := compareNum(pv.major, pw.major); c != 0 {
+		return c
+	}
+	if c := compareNum(pv.minor, pw.minor); c != 0 {
+		return c
+	}
+	if c := compareNum(pv.patch, pw.patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(pv.prerelease, pw.prerelease)
+}
+
+func parse(v string) (version, bool) {
+	var p version
+	v = strings.TrimPrefix(v, "v")
+
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		if !validIdents(v[i+1:], false) {
+			return p, false
+		}
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		if !validIdents(v[i+1:], true) {
+			return p, false
+		}
+		p.prerelease = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+
+	nums := strings.Split(v, ".")
+	if len(nums) > 3 {
+		return p, false
+	}
+	for _, n := range nums {
+		if !isNum(n) || (len(n) > 1 && n[0] == '0') {
+			return p, false
+		}
+	}
+	for len(nums) < 3 {
+		nums = append(nums, "0")
+	}
+	p.major, p.minor, p.patch = nums[0], nums[1], nums[2]
+	return p, true
+}
+
+// validIdents checks the dot-separated identifiers of a prerelease or build metadata suffix.
+func validIdents(s string, prerelease bool) bool {
+	for _, ident := range strings.Split(s, ".") {
+		if ident == "" {
+			return false
+		}
+		for _, c := range ident {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+		if prerelease && isNum(ident) && len(ident) > 1 && ident[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNum(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compareNum compares two decimal numbers without leading zeros of arbitrary length.
+func compareNum(a, b string) int {
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a, b []string) int {
+	// a version without prerelease has higher precedence
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, bn := isNum(a[i]), isNum(b[i])
+		var c int
+		switch {
+		case an && bn:
+			c = compareNum(a[i], b[i])
+		case an:
+			c = -1
+		case bn:
+			c = 1
+		default:
+			c = strings.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	// a larger set of prerelease fields has higher precedence if all preceding ones are equal
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
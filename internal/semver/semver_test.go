@@ -0,0 +1,48 @@
+package semver
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	// in increasing order, from https://semver.org/#spec-item-11
+	versions := []string{
+		"invalid",
+		"v1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.2",
+		"v1.2.1+build.5",
+		"v1.10.0",
+		"v2",
+	}
+	for i, v := range versions {
+		for j, w := range versions {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := Compare(v, w); got != want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", v, w, got, want)
+			}
+		}
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	for _, v := range []string{"v1.2.3", "1.2.3", "v1", "v1.2.3-rc.1+meta", "v0.0.0-20190101000000-abcdef012345"} {
+		if !IsValid(v) {
+			t.Errorf("IsValid(%q) = false, want true", v)
+		}
+	}
+	for _, v := range []string{"", "v", "v01.2.3", "v1.2.3.4", "v1.2.3-", "v1.2.3-01", "(devel)"} {
+		if IsValid(v) {
+			t.Errorf("IsValid(%q) = true, want false", v)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package update
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TrustedKey is a public key trusted to sign updates.
+type TrustedKey struct {
+	// ID identifies the key. It must be unique within a keyring.
+	ID string
+
+	// PublicKey is the key to verify signatures with.
+	PublicKey crypto.PublicKey
+
+	// Verifier is the signature verification algorithm for this key. If nil, Options.Verifier is used.
+	Verifier Verifier
+}
+
+// KeySignature is a signature of an update made with the trusted key identified by KeyID.
+type KeySignature struct {
+	KeyID     string
+	Signature []byte
+}
+
+// validateKeyring checks that the keyring, signatures and threshold make sense together.
+func (o *Options) validateKeyring() error {
+	switch {
+	case len(o.Keyring) == 0:
+		return errors.New("no keyring to verify signatures with")
+	case len(o.Signatures) == 0:
+		return errors.New("no signatures to verify with")
+	case o.Threshold < 0 || o.Threshold > len(o.Keyring):
+		return fmt.Errorf("threshold %d can't be met by a keyring of %d keys", o.Threshold, len(o.Keyring))
+	}
+
+	for i, key := range o.Keyring {
+		if key.PublicKey == nil {
+			return fmt.Errorf("trusted key %q has no public key", key.ID)
+		}
+		for _, other := range o.Keyring[:i] {
+			if key.ID == other.ID {
+				return fmt.Errorf("duplicate trusted key ID %q", key.ID)
+			}
+			// the same key under two IDs would count twice towards the threshold
+			if k, ok := key.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && k.Equal(other.PublicKey) {
+				return fmt.Errorf("trusted keys %q and %q are the same key", other.ID, key.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyThreshold checks that enough distinct trusted keys signed the checksum. Trusted keys
+// are those of the keyring and their successors, minus revoked keys. A successor counts as the
+// keyring key its chain of transitions starts from. Signatures by unknown keys and invalid
+// signatures are ignored.
+func (o *Options) verifyThreshold(checksum []byte) error {
+	threshold := o.Threshold
+	if threshold == 0 {
+		threshold = 1
+	}
+
+	keyring := o.trustedKeys(time.Now())
+	keys := make(map[string]trustedKey, len(keyring))
+	for _, key := range keyring {
+		keys[key.ID] = key
+	}
+
+	signed := make(map[string]bool)
+	for _, sig := range o.Signatures {
+		key, ok := keys[sig.KeyID]
+		if !ok || signed[key.root] {
+			continue
+		}
+		v := key.Verifier
+		if v == nil {
+			v = o.Verifier
+		}
+		if v.VerifySignature(checksum, sig.Signature, o.Hash, key.PublicKey) == nil {
+			signed[key.root] = true
+		}
+	}
+
+	if len(signed) < threshold {
+		return fmt.Errorf("update has valid signatures by %d trusted keys, %d required", len(signed), threshold)
+	}
+	return nil
+}
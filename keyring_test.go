@@ -0,0 +1,205 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func generateKeyring(n int, t *testing.T) ([]TrustedKey, []ed25519.PrivateKey) {
+	keyring := make([]TrustedKey, n)
+	privs := make([]ed25519.PrivateKey, n)
+	for i := range keyring {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		keyring[i] = TrustedKey{ID: fmt.Sprintf("key%d", i), PublicKey: pub}
+		privs[i] = priv
+	}
+	return keyring, privs
+}
+
+func TestVerifyThreshold(t *testing.T) {
+	fName := "TestVerifyThreshold"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	keyring, privs := generateKeyring(3, t)
+	_, untrusted := generateKeyring(1, t)
+
+	sig := func(id string, priv ed25519.PrivateKey) KeySignature {
+		return KeySignature{KeyID: id, Signature: ed25519.Sign(priv, newFileChecksum[:])}
+	}
+
+	tests := []struct {
+		name string
+		sigs []KeySignature
+		ok   bool
+	}{
+		{"OneOfTwo", []KeySignature{sig("key0", privs[0])}, false},
+		{"SameKeyTwice", []KeySignature{sig("key0", privs[0]), sig("key0", privs[0])}, false},
+		{"WrongKey", []KeySignature{sig("key0", privs[0]), sig("key1", untrusted[0])}, false},
+		{"UnknownKey", []KeySignature{sig("key0", privs[0]), sig("key3", untrusted[0])}, false},
+		{"TwoOfTwo", []KeySignature{sig("key0", privs[0]), sig("key2", privs[2])}, true},
+	}
+	for _, tt := range tests {
+		err := Apply(bytes.NewReader(newFile), Options{
+			TargetPath: fName,
+			Keyring:    keyring,
+			Signatures: tt.sigs,
+			Threshold:  2,
+		})
+		if tt.ok {
+			validateUpdate(fName, err, t)
+		} else if err == nil {
+			t.Fatalf("%s: Accepted an update without enough trusted signatures!", tt.name)
+		}
+	}
+}
+
+func TestKeyringDuplicateKey(t *testing.T) {
+	fName := "TestKeyringDuplicateKey"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	keyring, privs := generateKeyring(1, t)
+	keyring = append(keyring, TrustedKey{ID: "copy", PublicKey: keyring[0].PublicKey})
+
+	signature := ed25519.Sign(privs[0], newFileChecksum[:])
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Keyring:    keyring,
+		Signatures: []KeySignature{{"key0", signature}, {"copy", signature}},
+		Threshold:  2,
+	})
+	if err == nil {
+		t.Fatalf("Counted the same key twice towards the threshold!")
+	}
+}
+
+func signTransition(t *testing.T, signerID string, signer ed25519.PrivateKey, successor TrustedKey, expires time.Time) KeyTransition {
+	kt := KeyTransition{SignerID: signerID, Successor: successor, Expires: expires}
+	digest, err := kt.Digest(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to compute transition digest: %v", err)
+	}
+	kt.Signature = ed25519.Sign(signer, digest)
+	return kt
+}
+
+func TestKeyTransitions(t *testing.T) {
+	fName := "TestKeyTransitions"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	keys, privs := generateKeyring(3, t)
+	root, successor, next := keys[0], keys[1], keys[2]
+	expired := time.Now().Add(-time.Hour)
+
+	// the algorithm is part of the signed statement, so it can't be changed after signing
+	withAlgorithm := func(signed, algorithm string) KeyTransition {
+		kt := KeyTransition{SignerID: "key0", Successor: next, Algorithm: signed}
+		digest, err := kt.Digest(crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Failed to compute transition digest: %v", err)
+		}
+		kt.Signature = ed25519.Sign(privs[0], digest)
+		kt.Algorithm = algorithm
+		return kt
+	}
+	unsignedVerifier := signTransition(t, "key0", privs[0], next, time.Time{})
+	unsignedVerifier.Successor.Verifier = NewEd25519Verifier()
+
+	tests := []struct {
+		name        string
+		transitions []KeyTransition
+		revoked     []string
+		ok          bool
+	}{
+		{"NoTransition", nil, nil, false},
+		{"Direct", []KeyTransition{signTransition(t, "key0", privs[0], next, time.Time{})}, nil, true},
+		{"Chained", []KeyTransition{
+			// out of order on purpose
+			signTransition(t, "key1", privs[1], next, time.Time{}),
+			signTransition(t, "key0", privs[0], successor, time.Now().Add(time.Hour)),
+		}, nil, true},
+		{"Expired", []KeyTransition{signTransition(t, "key0", privs[0], next, expired)}, nil, false},
+		{"Forged", []KeyTransition{signTransition(t, "key0", privs[1], next, time.Time{})}, nil, false},
+		{"UntrustedSigner", []KeyTransition{signTransition(t, "key1", privs[1], next, time.Time{})}, nil, false},
+		{"RevokedSigner", []KeyTransition{signTransition(t, "key0", privs[0], next, time.Time{})}, []string{"key0"}, false},
+		{"Algorithm", []KeyTransition{withAlgorithm("ed25519", "ed25519")}, nil, true},
+		{"WrongAlgorithm", []KeyTransition{withAlgorithm("rsa", "rsa")}, nil, false},
+		{"SwappedAlgorithm", []KeyTransition{withAlgorithm("rsa", "ed25519")}, nil, false},
+		{"UnsignedVerifier", []KeyTransition{unsignedVerifier}, nil, false},
+	}
+	for _, tt := range tests {
+		err := Apply(bytes.NewReader(newFile), Options{
+			TargetPath:  fName,
+			Keyring:     []TrustedKey{root},
+			Signatures:  []KeySignature{{"key2", ed25519.Sign(privs[2], newFileChecksum[:])}},
+			Transitions: tt.transitions,
+			RevokedKeys: tt.revoked,
+		})
+		if tt.ok {
+			if err != nil {
+				t.Fatalf("%s: Failed to update: %v", tt.name, err)
+			}
+			validateUpdate(fName, err, t)
+		} else if err == nil {
+			t.Fatalf("%s: Accepted an update signed by an untrusted key!", tt.name)
+		}
+	}
+}
+
+func TestKeyTransitionsThreshold(t *testing.T) {
+	fName := "TestKeyTransitionsThreshold"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	keys, privs := generateKeyring(3, t)
+	sig := func(id string, priv ed25519.PrivateKey) KeySignature {
+		return KeySignature{KeyID: id, Signature: ed25519.Sign(priv, newFileChecksum[:])}
+	}
+
+	// key0 authorizes key2, which is then just another key of the key0 holder
+	transitions := []KeyTransition{signTransition(t, "key0", privs[0], keys[2], time.Time{})}
+	tests := []struct {
+		name string
+		sigs []KeySignature
+		ok   bool
+	}{
+		{"KeyAndOwnSuccessor", []KeySignature{sig("key0", privs[0]), sig("key2", privs[2])}, false},
+		{"SuccessorAndOtherKey", []KeySignature{sig("key2", privs[2]), sig("key1", privs[1])}, true},
+	}
+	for _, tt := range tests {
+		err := Apply(bytes.NewReader(newFile), Options{
+			TargetPath:  fName,
+			Keyring:     keys[:2],
+			Signatures:  tt.sigs,
+			Threshold:   2,
+			Transitions: transitions,
+		})
+		if tt.ok {
+			validateUpdate(fName, err, t)
+		} else if err == nil {
+			t.Fatalf("%s: Accepted an update without enough independent trusted signatures!", tt.name)
+		}
+	}
+
+	// successors don't make a threshold beyond the size of the keyring reachable
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath:  fName,
+		Keyring:     keys[:2],
+		Signatures:  []KeySignature{sig("key0", privs[0]), sig("key1", privs[1]), sig("key2", privs[2])},
+		Threshold:   3,
+		Transitions: transitions,
+	})
+	if err == nil {
+		t.Fatalf("Accepted a threshold larger than the keyring")
+	}
+}
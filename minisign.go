@@ -0,0 +1,265 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/inconshreveable/go-update/internal/blake2b"
+)
+
+const (
+	minisignUntrustedPrefix = "untrusted comment:"
+	minisignTrustedPrefix   = "trusted comment: "
+)
+
+// MinisignPublicKey is an Ed25519 public key in the format used by minisign
+// (https://jedisct1.github.io/minisign/) and signify (https://man.openbsd.org/signify).
+type MinisignPublicKey struct {
+	// KeyID identifies the key. Signatures name the ID of the key that made them.
+	KeyID [8]byte
+
+	// Key is the public key itself.
+	Key ed25519.PublicKey
+}
+
+// ParseMinisignPublicKey parses a minisign or signify public key. It accepts both the contents
+// of a public key file and just the base64 encoded key, like the one passed to minisign -P.
+func ParseMinisignPublicKey(s string) (*MinisignPublicKey, error) {
+	lines := minisignLines([]byte(s))
+	if len(lines) != 1 {
+		return nil, errors.New("couldn't find a minisign public key")
+	}
+	alg, keyID, key, err := decodeMinisignLine(lines[0], ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if alg != "Ed" {
+		return nil, fmt.Errorf("unsupported minisign public key algorithm %q", alg)
+	}
+	return &MinisignPublicKey{KeyID: keyID, Key: ed25519.PublicKey(key)}, nil
+}
+
+// MinisignSignature is a parsed minisign signature file (.minisig).
+type MinisignSignature struct {
+	// Prehashed reports whether the signature covers the BLAKE2b-512 checksum of the signed file,
+	// the default since minisign 0.10, rather than the file itself.
+	Prehashed bool
+
+	// KeyID is the ID of the key that made the signature.
+	KeyID [8]byte
+
+	// Signature is the signature of the file or its checksum.
+	Signature []byte
+
+	// TrustedComment is the comment covered by GlobalSignature, e.g. "timestamp:1556193335 file:update".
+	TrustedComment string
+
+	// GlobalSignature is the signature of Signature followed by TrustedComment.
+	GlobalSignature []byte
+}
+
+// ParseMinisignSignature parses the contents of a minisign signature file.
+func ParseMinisignSignature(data []byte) (*MinisignSignature, error) {
+	lines := minisignLines(data)
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], minisignTrustedPrefix) {
+		return nil, errors.New("couldn't parse minisign signature")
+	}
+
+	alg, keyID, sig, err := decodeMinisignLine(lines[0], ed25519.SignatureSize)
+	if err != nil {
+		return nil, err
+	}
+	if alg != "Ed" && alg != "ED" {
+		return nil, fmt.Errorf("unsupported minisign signature algorithm %q", alg)
+	}
+
+	global, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return nil, err
+	}
+	if len(global) != ed25519.SignatureSize {
+		return nil, errors.New("minisign global signature has the wrong length")
+	}
+
+	return &MinisignSignature{
+		Prehashed:       alg == "ED",
+		KeyID:           keyID,
+		Signature:       sig,
+		TrustedComment:  strings.TrimPrefix(lines[1], minisignTrustedPrefix),
+		GlobalSignature: global,
+	}, nil
+}
+
+// verify checks both signatures of s against message, which is the file or its checksum.
+func (s *MinisignSignature) verify(key *MinisignPublicKey, message []byte) error {
+	if s.KeyID != key.KeyID {
+		return fmt.Errorf("signature was made with key %X, not with key %X", s.KeyID, key.KeyID)
+	}
+	if !ed25519.Verify(key.Key, message, s.Signature) {
+		return errors.New("failed to verify minisign signature")
+	}
+	global := append(append([]byte{}, s.Signature...), s.TrustedComment...)
+	if !ed25519.Verify(key.Key, global, s.GlobalSignature) {
+		return errors.New("failed to verify minisign trusted comment")
+	}
+	return nil
+}
+
+// MaxPayloadSize is the largest update that signatures of the update itself, i.e. signify and
+// legacy minisign signatures, can be verified for. Such updates have to be read into memory in
+// their entirety, so larger ones are rejected. Use prehashed minisign signatures, or signatures
+// of the checksum, for larger updates.
+const MaxPayloadSize = 256 << 20
+
+// maxPayloadSize is MaxPayloadSize, lowered in tests.
+var maxPayloadSize int64 = MaxPayloadSize
+
+// readPayload reads an update that's signed itself into memory.
+func readPayload(payload io.Reader) ([]byte, error) {
+	message, err := ioutil.ReadAll(io.LimitReader(payload, maxPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(message)) > maxPayloadSize {
+		return nil, fmt.Errorf("update is larger than %d bytes, the maximum for signatures of the update itself", maxPayloadSize)
+	}
+	return message, nil
+}
+
+type minisignVerifier struct{}
+
+// NewMinisignVerifier returns a Verifier for minisign signatures. It expects the contents of the
+// .minisig file as Options.Signature and a *MinisignPublicKey as Options.PublicKey. Both the
+// signature and its trusted comment are verified.
+//
+// Prehashed signatures are verified while streaming the update. Legacy signatures, made with
+// minisign -l or versions before 0.10, cover the update itself, which is then read into memory in
+// its entirety, up to MaxPayloadSize. In a keyring, only prehashed signatures are supported, and only with Options.Hash
+// set to crypto.BLAKE2b_512.
+func NewMinisignVerifier() Verifier {
+	return minisignVerifier{}
+}
+
+func (minisignVerifier) VerifySignature(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+	key, sig, err := minisignArgs(signature, publicKey)
+	if err != nil {
+		return err
+	}
+	if !sig.Prehashed || hash != crypto.BLAKE2b_512 {
+		return errors.New("minisign signatures can only be verified against BLAKE2b-512 checksums")
+	}
+	return sig.verify(key, checksum)
+}
+
+func (minisignVerifier) VerifyPayload(payload io.Reader, signature []byte, publicKey crypto.PublicKey) error {
+	key, sig, err := minisignArgs(signature, publicKey)
+	if err != nil {
+		return err
+	}
+
+	var message []byte
+	if sig.Prehashed {
+		h := blake2b.New512()
+		if _, err = io.Copy(h, payload); err != nil {
+			return err
+		}
+		message = h.Sum(nil)
+	} else if message, err = readPayload(payload); err != nil {
+		return err
+	}
+	return sig.verify(key, message)
+}
+
+func minisignArgs(signature []byte, publicKey crypto.PublicKey) (*MinisignPublicKey, *MinisignSignature, error) {
+	key, ok := publicKey.(*MinisignPublicKey)
+	if !ok {
+		return nil, nil, errors.New("not a valid minisign public key")
+	}
+	sig, err := ParseMinisignSignature(signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, sig, nil
+}
+
+type signifyVerifier struct{}
+
+// NewSignifyVerifier returns a Verifier for signify signatures. It expects the contents of the
+// .sig file as Options.Signature and a *MinisignPublicKey, parsed from the signify public key,
+// as Options.PublicKey. Signify signatures cover the update itself, which is read into memory
+// in its entirety for verification, so updates larger than MaxPayloadSize are rejected. They
+// can't be used in a keyring.
+func NewSignifyVerifier() Verifier {
+	return signifyVerifier{}
+}
+
+func (signifyVerifier) VerifySignature(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+	return errors.New("signify signatures cover the update itself, not its checksum")
+}
+
+func (signifyVerifier) VerifyPayload(payload io.Reader, signature []byte, publicKey crypto.PublicKey) error {
+	key, ok := publicKey.(*MinisignPublicKey)
+	if !ok {
+		return errors.New("not a valid signify public key")
+	}
+
+	lines := minisignLines(signature)
+	if len(lines) != 1 {
+		return errors.New("couldn't parse signify signature")
+	}
+	alg, keyID, sig, err := decodeMinisignLine(lines[0], ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+	if alg != "Ed" {
+		return fmt.Errorf("unsupported signify signature algorithm %q", alg)
+	}
+	if keyID != key.KeyID {
+		return fmt.Errorf("signature was made with key %X, not with key %X", keyID, key.KeyID)
+	}
+
+	message, err := readPayload(payload)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key.Key, message, sig) {
+		return errors.New("failed to verify signify signature")
+	}
+	return nil
+}
+
+// minisignLines returns the non-empty lines of a minisign or signify file,
+// skipping untrusted comments.
+func minisignLines(data []byte) []string {
+	var lines []string
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		// trailing whitespace of the trusted comment is signed, so only strip line endings
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 || bytes.HasPrefix(line, []byte(minisignUntrustedPrefix)) {
+			continue
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// decodeMinisignLine decodes a base64 encoded line made of a 2 byte algorithm,
+// an 8 byte key ID and a payload of n bytes.
+func decodeMinisignLine(line string, n int) (alg string, keyID [8]byte, payload []byte, err error) {
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return "", keyID, nil, err
+	}
+	if len(raw) != 2+len(keyID)+n {
+		return "", keyID, nil, errors.New("minisign data has the wrong length")
+	}
+	copy(keyID[:], raw[2:])
+	return string(raw[:2]), keyID, raw[2+len(keyID):], nil
+}
@@ -0,0 +1,159 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/inconshreveable/go-update/internal/blake2b"
+)
+
+var minisignKeyID = [8]byte{0xE7, 0x62, 0x0F, 0x18, 0x42, 0xB4, 0xE8, 0x1F}
+
+func minisignLine(alg string, payload []byte) string {
+	raw := append(append([]byte(alg), minisignKeyID[:]...), payload...)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func generateMinisignKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return "untrusted comment: minisign public key E7620F1842B4E81F\n" + minisignLine("Ed", pub) + "\n", priv
+}
+
+func minisign(priv ed25519.PrivateKey, data []byte, prehashed bool, comment string) []byte {
+	alg, message := "Ed", data
+	if prehashed {
+		sum := blake2b.Sum512(data)
+		alg, message = "ED", sum[:]
+	}
+	sig := ed25519.Sign(priv, message)
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		minisignLine(alg, sig), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerifyMinisignSignature(t *testing.T) {
+	fName := "TestVerifyMinisignSignature"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	pubFile, priv := generateMinisignKey(t)
+	pub, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	for _, prehashed := range []bool{true, false} {
+		sig := minisign(priv, newFile, prehashed, "timestamp:1556193335\tfile:update")
+		err = Apply(bytes.NewReader(newFile), Options{
+			TargetPath: fName,
+			PublicKey:  pub,
+			Signature:  sig,
+			Verifier:   NewMinisignVerifier(),
+		})
+		validateUpdate(fName, err, t)
+
+		// tampering with the trusted comment invalidates the global signature
+		tampered := bytes.Replace(sig, []byte("timestamp:1556193335"), []byte("timestamp:1556193336"), 1)
+		err = Apply(bytes.NewReader(newFile), Options{
+			TargetPath: fName,
+			PublicKey:  pub,
+			Signature:  tampered,
+			Verifier:   NewMinisignVerifier(),
+		})
+		if err == nil {
+			t.Fatalf("Accepted a minisign signature with a tampered trusted comment!")
+		}
+	}
+
+	// prehashed signatures also work against BLAKE2b-512 checksums, e.g. in a keyring
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Hash:       crypto.BLAKE2b_512,
+		Keyring:    []TrustedKey{{ID: "minisign", PublicKey: pub, Verifier: NewMinisignVerifier()}},
+		Signatures: []KeySignature{{"minisign", minisign(priv, newFile, true, "")}},
+	})
+	validateUpdate(fName, err, t)
+}
+
+func TestVerifySignifySignature(t *testing.T) {
+	fName := "TestVerifySignifySignature"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	pubFile, priv := generateMinisignKey(t)
+	pub, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	sig := "untrusted comment: verify with update.pub\n" + minisignLine("Ed", ed25519.Sign(priv, newFile)) + "\n"
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		PublicKey:  pub,
+		Signature:  []byte(sig),
+		Verifier:   NewSignifyVerifier(),
+	})
+	validateUpdate(fName, err, t)
+
+	sig = "untrusted comment: verify with update.pub\n" + minisignLine("Ed", ed25519.Sign(priv, oldFile)) + "\n"
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		PublicKey:  pub,
+		Signature:  []byte(sig),
+		Verifier:   NewSignifyVerifier(),
+	})
+	if err == nil {
+		t.Fatalf("Accepted a signify signature of another file!")
+	}
+}
+
+func TestVerifyPayloadMaxSize(t *testing.T) {
+	fName := "TestVerifyPayloadMaxSize"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	maxPayloadSize = int64(len(newFile)) - 1
+	defer func() { maxPayloadSize = MaxPayloadSize }()
+
+	pubFile, priv := generateMinisignKey(t)
+	pub, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	signify := "untrusted comment: verify with update.pub\n" + minisignLine("Ed", ed25519.Sign(priv, newFile)) + "\n"
+	for _, tt := range []struct {
+		verifier  Verifier
+		signature []byte
+	}{
+		{NewSignifyVerifier(), []byte(signify)},
+		{NewMinisignVerifier(), minisign(priv, newFile, false, "")},
+	} {
+		err = Apply(bytes.NewReader(newFile), Options{
+			TargetPath: fName,
+			PublicKey:  pub,
+			Signature:  tt.signature,
+			Verifier:   tt.verifier,
+		})
+		if err == nil {
+			t.Fatalf("Verified a signature of an update larger than the maximum payload size")
+		}
+	}
+
+	// prehashed signatures are streamed, so they have no limit
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		PublicKey:  pub,
+		Signature:  minisign(priv, newFile, true, ""),
+		Verifier:   NewMinisignVerifier(),
+	})
+	validateUpdate(fName, err, t)
+}
@@ -0,0 +1,68 @@
+package update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// PreserveError is returned by Apply when PreserveAttributes is set and some attributes of
+// the existing target could not be copied to its replacement. The target is left untouched.
+type PreserveError struct {
+	// Failed maps the name of each attribute that could not be copied to the reason why.
+	// Names are "mode", "owner" or the name of an extended attribute, like "security.capability".
+	Failed map[string]error
+}
+
+func (e *PreserveError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %v", name, e.Failed[name])
+	}
+	return "failed to preserve attributes of target: " + strings.Join(msgs, "; ")
+}
+
+// preserveAttributes copies the owner, mode and extended attributes of the target to path.
+func (o *Options) preserveAttributes(path string) error {
+	info, err := os.Stat(o.TargetPath)
+	if err != nil {
+		return err
+	}
+
+	xattrs := o.PreserveXattrs
+	if xattrs == nil {
+		xattrs = defaultPreserveXattrs
+	}
+
+	failed := make(map[string]error)
+
+	// the order matters: changing the owner clears the setuid and setgid bits as well as
+	// file capabilities, so those must be restored afterwards
+	if err := chownLike(path, info); err != nil {
+		failed["owner"] = err
+	}
+	mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(path, mode); err != nil {
+		failed["mode"] = err
+	}
+	for _, name := range xattrs {
+		if err := copyXattr(o.TargetPath, path, name); err != nil {
+			failed[name] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PreserveError{Failed: failed}
+	}
+	return nil
+}
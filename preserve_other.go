@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package update
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike changes the owner and group of path to those of info.
+func chownLike(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Chown(path, int(stat.Uid), int(stat.Gid))
+}
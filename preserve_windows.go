@@ -0,0 +1,8 @@
+package update
+
+import "os"
+
+// chownLike is a no-op on Windows, where new files inherit their access control from the directory.
+func chownLike(path string, info os.FileInfo) error {
+	return nil
+}
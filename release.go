@@ -0,0 +1,326 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ReleaseManifest describes a release and the artifacts that install it on each platform.
+// It's meant to be published as a SignedManifest:
+//
+//	{
+//	  "version": "1.5.0",
+//	  "notes_url": "https://example.com/tool/1.5.0",
+//	  "date": "2024-05-01T12:00:00Z",
+//	  "artifacts": {
+//	    "linux-amd64": {
+//	      "url": "https://example.com/tool/1.5.0/tool_linux_amd64",
+//	      "size": 8123456,
+//	      "hash": "sha256",
+//	      "checksum": "4d8f...",
+//	      "signature": "MEUCIQ...",
+//	      "patches": {
+//	        "9a1c...": {"url": "https://example.com/tool/1.5.0/tool_linux_amd64.from-1.4.2.bsdiff", "size": 81234}
+//	      }
+//	    }
+//	  }
+//	}
+type ReleaseManifest struct {
+	Version   string               `json:"version"`
+	NotesURL  string               `json:"notes_url,omitempty"`
+	Date      time.Time            `json:"date"`
+	Artifacts map[string]*Artifact `json:"artifacts"`
+}
+
+// Artifact is the build of a release for one platform.
+type Artifact struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+
+	// Hash names the hash function of Checksum: sha256, sha384, sha512 or blake2b.
+	// If empty, defaults to sha256.
+	Hash string `json:"hash,omitempty"`
+
+	// Hex encoded checksum of the artifact.
+	Checksum string `json:"checksum"`
+
+	// Signature of the artifact, like Options.Signature. Optional if the signature
+	// of the manifest is enough.
+	Signature []byte `json:"signature,omitempty"`
+
+	// Patches from previous releases, keyed by the hex encoded checksum of the file they apply to.
+	Patches map[string]*Patch `json:"patches,omitempty"`
+}
+
+// Patch is a binary patch that turns a previous release into an Artifact.
+type Patch struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+
+	// Format of the patch. If empty, defaults to bsdiff, the only supported format.
+	Format string `json:"format,omitempty"`
+
+	// Hex encoded checksum of the patch itself, with the Hash of its Artifact. Optional.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// SignedManifest is the envelope a ReleaseManifest is published in. The manifest is
+// kept as the exact bytes that were signed, so it never has to be reserialized to be verified.
+type SignedManifest struct {
+	Manifest  []byte `json:"manifest"`
+	Signature []byte `json:"signature"`
+}
+
+// ParseReleaseManifest parses and validates a JSON ReleaseManifest. It doesn't verify any
+// signature, see SignedManifest.Verify.
+func ParseReleaseManifest(data []byte) (*ReleaseManifest, error) {
+	var m ReleaseManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *ReleaseManifest) validate() error {
+	if m.Version == "" {
+		return errors.New("release manifest has no version")
+	}
+	for platform, a := range m.Artifacts {
+		if a == nil {
+			return fmt.Errorf("release manifest has no artifact for %s", platform)
+		}
+		hash, err := a.hash()
+		if err != nil {
+			return fmt.Errorf("artifact %s: %v", platform, err)
+		}
+		if _, err := decodeChecksum(hash, a.Checksum); err != nil {
+			return fmt.Errorf("artifact %s: %v", platform, err)
+		}
+		for source, p := range a.Patches {
+			if _, err := decodeChecksum(hash, source); err != nil {
+				return fmt.Errorf("artifact %s: patch from %s: %v", platform, source, err)
+			}
+			if p == nil {
+				return fmt.Errorf("artifact %s: no patch from %s", platform, source)
+			}
+			if p.Checksum != "" {
+				if _, err := decodeChecksum(hash, p.Checksum); err != nil {
+					return fmt.Errorf("artifact %s: patch from %s: %v", platform, source, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalCanonical returns the canonical JSON serialization of the manifest, the bytes
+// to sign for a SignedManifest: no insignificant whitespace, fields in declaration order,
+// map keys sorted, no HTML escaping and the date in UTC.
+func (m *ReleaseManifest) MarshalCanonical() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	c := *m
+	c.Date = c.Date.UTC()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&c); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Artifact returns the artifact for the given platform.
+func (m *ReleaseManifest) Artifact(goos, goarch string) (*Artifact, error) {
+	a, ok := m.Artifacts[goos+"-"+goarch]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no artifact for %s/%s", m.Version, goos, goarch)
+	}
+	return a, nil
+}
+
+// PlatformArtifact returns the artifact for the platform of the running program.
+func (m *ReleaseManifest) PlatformArtifact() (*Artifact, error) {
+	return m.Artifact(runtime.GOOS, runtime.GOARCH)
+}
+
+// Verify verifies the signature of the manifest and parses it. The signature is checked
+// the same way Apply checks the signature of an update: with verifier, which defaults to
+// NewAutoVerifier, over the checksum of the manifest computed with hash, which defaults
+// to SHA256. A PayloadVerifier gets to see the manifest itself instead.
+func (s *SignedManifest) Verify(hash crypto.Hash, publicKey crypto.PublicKey, verifier Verifier) (*ReleaseManifest, error) {
+	if err := verifyDetached(s.Manifest, s.Signature, hash, publicKey, verifier); err != nil {
+		return nil, err
+	}
+	return ParseReleaseManifest(s.Manifest)
+}
+
+// SetArtifact is a convenience method to set the Checksum, Hash and Signature properties
+// to those of a release artifact. PublicKey and Verifier are left for the caller to set.
+func (o *Options) SetArtifact(a *Artifact) error {
+	hash, err := a.hash()
+	if err != nil {
+		return err
+	}
+	checksum, err := decodeChecksum(hash, a.Checksum)
+	if err != nil {
+		return err
+	}
+	o.Hash, o.Checksum, o.Signature = hash, checksum, a.Signature
+	return nil
+}
+
+// SetPatch is a convenience method to set the Patcher property to one that applies the given patch.
+// The Patcher also checks that the patch it's given has the patch's Size and Checksum, if they're set,
+// computing the checksum with the Hash set by SetArtifact. A patch that doesn't match is rejected
+// with a *VerificationError.
+func (o *Options) SetPatch(p *Patch) error {
+	var patcher Patcher
+	switch p.Format {
+	case "", "bsdiff":
+		patcher = NewBSDiffPatcher()
+	default:
+		return fmt.Errorf("unsupported patch format %q", p.Format)
+	}
+
+	hash := o.Hash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	var checksum []byte
+	if p.Checksum != "" {
+		var err error
+		if checksum, err = decodeChecksum(hash, p.Checksum); err != nil {
+			return err
+		}
+	}
+	o.Patcher = &checkedPatcher{patcher, p.Size, hash, checksum}
+	return nil
+}
+
+// checkedPatcher verifies the size and checksum of a patch while applying it.
+type checkedPatcher struct {
+	Patcher
+	size     int64
+	hash     crypto.Hash
+	checksum []byte
+}
+
+func (p *checkedPatcher) Patch(old io.Reader, new io.Writer, patch io.Reader) error {
+	if p.checksum != nil && !p.hash.Available() {
+		return errors.New("requested hash function not available")
+	}
+	if p.size > 0 {
+		// one more byte than expected is enough to tell the patch is too large
+		patch = io.LimitReader(patch, p.size+1)
+	}
+	counter := &countingHash{}
+	if p.checksum != nil {
+		counter.h = p.hash.New()
+	}
+	patch = io.TeeReader(patch, counter)
+
+	if err := p.Patcher.Patch(old, new, patch); err != nil {
+		return err
+	}
+	// the patcher doesn't necessarily read the patch to its end
+	if _, err := io.Copy(ioutil.Discard, patch); err != nil {
+		return err
+	}
+
+	if p.size > 0 && counter.n != p.size {
+		return &VerificationError{fmt.Errorf("Patch has wrong size. Expected: %d, got: %d", p.size, counter.n)}
+	}
+	if p.checksum != nil {
+		if sum := counter.h.Sum(nil); !bytes.Equal(sum, p.checksum) {
+			return &VerificationError{fmt.Errorf("Patch has wrong checksum. Expected: %x, got: %x", p.checksum, sum)}
+		}
+	}
+	return nil
+}
+
+// countingHash counts the bytes written to it and hashes them with h, if set.
+type countingHash struct {
+	h hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.h != nil {
+		c.h.Write(p)
+	}
+	return len(p), nil
+}
+
+// PatchFrom returns the patch that turns the file at path into the artifact,
+// or nil if there is none.
+func (a *Artifact) PatchFrom(path string) (*Patch, error) {
+	if len(a.Patches) == 0 {
+		return nil, nil
+	}
+	hash, err := a.hash()
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, errors.New("requested hash function not available")
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	h := hash.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return nil, err
+	}
+
+	source := h.Sum(nil)
+	for key, p := range a.Patches {
+		if checksum, _ := hex.DecodeString(key); bytes.Equal(checksum, source) {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (a *Artifact) hash() (crypto.Hash, error) {
+	if a.Hash == "" {
+		return crypto.SHA256, nil
+	}
+	for name, hash := range checksumHashes {
+		if strings.EqualFold(name, a.Hash) {
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm %s", a.Hash)
+}
+
+func decodeChecksum(hash crypto.Hash, s string) ([]byte, error) {
+	checksum, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(checksum) != hash.Size() {
+		return nil, fmt.Errorf("%v checksum has the wrong length %d", hash, len(checksum))
+	}
+	return checksum, nil
+}
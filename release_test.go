@@ -0,0 +1,171 @@
+package update
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/go-update/internal/binarydist"
+)
+
+func releaseManifest(t *testing.T) *ReleaseManifest {
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader(oldFile), bytes.NewReader(newFile), &patch); err != nil {
+		t.Fatalf("Failed to create patch: %v", err)
+	}
+	oldChecksum := sha256.Sum256(oldFile)
+
+	return &ReleaseManifest{
+		Version:  "1.5.0",
+		NotesURL: "https://example.com/notes?v=1.5.0&lang=en",
+		Date:     time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		Artifacts: map[string]*Artifact{
+			runtime.GOOS + "-" + runtime.GOARCH: {
+				URL:       "https://example.com/tool",
+				Size:      int64(len(newFile)),
+				Checksum:  fmt.Sprintf("%x", newFileChecksum),
+				Signature: signec(ecdsaPrivateKey, newFile, t),
+				Patches: map[string]*Patch{
+					fmt.Sprintf("%x", oldChecksum): {URL: "https://example.com/tool.bsdiff", Size: int64(patch.Len())},
+				},
+			},
+			"plan9-386": {
+				URL:      "https://example.com/tool",
+				Hash:     "sha512",
+				Checksum: fmt.Sprintf("%x", bytes.Repeat([]byte{0xAB}, 64)),
+			},
+		},
+	}
+}
+
+func TestReleaseManifestCanonical(t *testing.T) {
+	m := releaseManifest(t)
+	canonical, err := m.MarshalCanonical()
+	if err != nil {
+		t.Fatalf("Failed to serialize manifest: %v", err)
+	}
+	if !bytes.Contains(canonical, []byte(`"notes_url":"https://example.com/notes?v=1.5.0&lang=en","date":"2024-05-01T12:00:00Z"`)) {
+		t.Fatalf("Manifest is not serialized canonically: %s", canonical)
+	}
+
+	parsed, err := ParseReleaseManifest(canonical)
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+	again, err := parsed.MarshalCanonical()
+	if err != nil {
+		t.Fatalf("Failed to serialize manifest: %v", err)
+	}
+	if !bytes.Equal(canonical, again) {
+		t.Fatalf("Serialization is not stable:\n%s\n%s", canonical, again)
+	}
+
+	m.Artifacts["plan9-386"].Checksum = "abcd"
+	if _, err := m.MarshalCanonical(); err == nil {
+		t.Fatalf("Serialized a manifest with a malformed checksum")
+	}
+}
+
+func TestApplyReleaseManifest(t *testing.T) {
+	fName := "TestApplyReleaseManifest"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	canonical, err := releaseManifest(t).MarshalCanonical()
+	if err != nil {
+		t.Fatalf("Failed to serialize manifest: %v", err)
+	}
+	envelope, err := json.Marshal(SignedManifest{Manifest: canonical, Signature: signec(ecdsaPrivateKey, canonical, t)})
+	if err != nil {
+		t.Fatalf("Failed to serialize envelope: %v", err)
+	}
+
+	opts := Options{TargetPath: fName}
+	if err := opts.SetPublicKeyPEM([]byte(ecdsaPublicKey)); err != nil {
+		t.Fatalf("Could not parse public key: %v", err)
+	}
+
+	var signed SignedManifest
+	if err := json.Unmarshal(envelope, &signed); err != nil {
+		t.Fatalf("Failed to parse envelope: %v", err)
+	}
+	m, err := signed.Verify(0, opts.PublicKey, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+
+	a, err := m.PlatformArtifact()
+	if err != nil {
+		t.Fatalf("No artifact for the running platform: %v", err)
+	}
+	if err := opts.SetArtifact(a); err != nil {
+		t.Fatalf("Failed to set artifact: %v", err)
+	}
+	p, err := a.PatchFrom(fName)
+	if err != nil || p == nil {
+		t.Fatalf("No patch from the old file: %v", err)
+	}
+	if err := opts.SetPatch(p); err != nil {
+		t.Fatalf("Failed to set patch: %v", err)
+	}
+
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader(oldFile), bytes.NewReader(newFile), &patch); err != nil {
+		t.Fatalf("Failed to create patch: %v", err)
+	}
+	err = Apply(&patch, opts)
+	validateUpdate(fName, err, t)
+
+	if p, err := a.PatchFrom(fName); err != nil || p != nil {
+		t.Fatalf("Found a patch from the new file: %v", err)
+	}
+
+	signed.Manifest = bytes.Replace(signed.Manifest, []byte("1.5.0"), []byte("1.6.0"), 1)
+	if _, err := signed.Verify(0, opts.PublicKey, nil); err == nil {
+		t.Fatalf("Accepted a tampered manifest!")
+	}
+}
+
+func TestApplyReleasePatchVerification(t *testing.T) {
+	fName := "TestApplyReleasePatchVerification"
+	defer cleanup(fName)
+
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader(oldFile), bytes.NewReader(newFile), &patch); err != nil {
+		t.Fatalf("Failed to create patch: %v", err)
+	}
+	sum := sha256.Sum256(patch.Bytes())
+	checksum := fmt.Sprintf("%x", sum)
+
+	tests := []struct {
+		name  string
+		patch Patch
+		ok    bool
+	}{
+		{"Match", Patch{Size: int64(patch.Len()), Checksum: checksum}, true},
+		{"Unchecked", Patch{}, true},
+		{"WrongSize", Patch{Size: int64(patch.Len()) - 1, Checksum: checksum}, false},
+		{"WrongChecksum", Patch{Checksum: fmt.Sprintf("%x", newFileChecksum)}, false},
+	}
+	for _, tt := range tests {
+		writeOldFile(fName, t)
+		opts := Options{TargetPath: fName}
+		if err := opts.SetArtifact(&Artifact{Checksum: fmt.Sprintf("%x", newFileChecksum)}); err != nil {
+			t.Fatalf("Failed to set artifact: %v", err)
+		}
+		if err := opts.SetPatch(&tt.patch); err != nil {
+			t.Fatalf("Failed to set patch: %v", err)
+		}
+
+		err := Apply(bytes.NewReader(patch.Bytes()), opts)
+		if tt.ok {
+			validateUpdate(fName, err, t)
+		} else if _, ok := err.(*VerificationError); !ok {
+			t.Fatalf("%s: Expected a *VerificationError, got: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Rollback undoes an update by moving the previous executable that Apply saved at
+// opts.OldSavePath back to opts.TargetPath (or the current executable, if not set).
+//
+// If opts.RollbackSavePath is set, the executable being rolled back is kept at that path,
+// e.g. for forensics. Otherwise it's removed, or hidden on Windows, just like the old
+// executable after an update.
+//
+// Rollback replaces the target the same way Apply does, according to opts.Swap, opts.Symlink
+// and opts.Durability. With SwapRename, a failure can leave the file system in the same kind of
+// inconsistent state as a failed Apply: applications should always call RollbackError on any
+// non-nil error returned by Rollback.
+func Rollback(opts Options) error {
+	if opts.OldSavePath == "" {
+		return errors.New("no OldSavePath to roll back to")
+	}
+
+	var err error
+	opts.TargetPath, err = opts.getPath()
+	if err != nil {
+		return err
+	}
+
+	switch opts.Symlink {
+	case SymlinkFollow:
+		if opts.TargetPath, err = filepath.EvalSymlinks(opts.TargetPath); err != nil {
+			return err
+		}
+	case SymlinkRetarget:
+		return errors.New("roll back by retargeting the symbolic link instead")
+	}
+
+	if _, err = os.Stat(opts.OldSavePath); err != nil {
+		return err
+	}
+
+	// Apply hides the old executable on Windows if it can't remove it
+	_ = unhideFile(opts.OldSavePath)
+
+	badPath := opts.RollbackSavePath
+	removeBad := badPath == ""
+	if removeBad {
+		badPath = filepath.Join(filepath.Dir(opts.TargetPath), fmt.Sprintf(".%s.old", filepath.Base(opts.TargetPath)))
+	}
+
+	return opts.swap(opts.OldSavePath, badPath, removeBad)
+}
@@ -0,0 +1,334 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const sshsigMagic = "SSHSIG"
+
+// SSHAllowedSigner is a key trusted to make SSH signatures, as listed in an
+// allowed_signers file (see the ALLOWED SIGNERS section of ssh-keygen(1)).
+type SSHAllowedSigner struct {
+	// Principals are the identities the key belongs to, e.g. "dev@example.com".
+	Principals []string
+
+	// Namespaces restricts the namespaces the key is trusted to sign in. Empty means any namespace.
+	Namespaces []string
+
+	// PublicKey is an ed25519.PublicKey or an *ecdsa.PublicKey on the P-256 curve.
+	PublicKey crypto.PublicKey
+}
+
+// ParseSSHAllowedSigners parses the contents of an allowed_signers file. Only the namespaces
+// option is supported; lines with other options, like cert-authority or valid-before, are
+// rejected rather than silently trusted without their restrictions.
+func ParseSSHAllowedSigners(data []byte) ([]SSHAllowedSigner, error) {
+	var signers []SSHAllowedSigner
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signer, err := parseSSHAllowedSigner(line)
+		if err != nil {
+			return nil, fmt.Errorf("allowed signers line %d: %v", i+1, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func parseSSHAllowedSigner(line string) (SSHAllowedSigner, error) {
+	var signer SSHAllowedSigner
+	fields := splitQuoted(line)
+	if len(fields) < 3 {
+		return signer, errors.New("expected principals, key type and key")
+	}
+	signer.Principals = strings.Split(unquote(fields[0]), ",")
+	fields = fields[1:]
+
+	// the options are optional, key types never contain an =
+	if !strings.HasPrefix(fields[0], "ssh-") && !strings.HasPrefix(fields[0], "ecdsa-") {
+		for _, opt := range splitOptions(fields[0]) {
+			name, value := opt, ""
+			if i := strings.IndexByte(opt, '='); i >= 0 {
+				name, value = opt[:i], unquote(opt[i+1:])
+			}
+			if strings.ToLower(name) != "namespaces" {
+				return signer, fmt.Errorf("unsupported option %q", name)
+			}
+			signer.Namespaces = strings.Split(value, ",")
+		}
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return signer, errors.New("expected key type and key")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return signer, err
+	}
+	keyType, key, err := parseSSHPublicKey(blob)
+	if err != nil {
+		return signer, err
+	}
+	if keyType != fields[0] {
+		return signer, fmt.Errorf("key type %s doesn't match key of type %s", fields[0], keyType)
+	}
+	signer.PublicKey = key
+	return signer, nil
+}
+
+// NewSSHSigVerifier returns a Verifier for signatures made with ssh-keygen -Y sign -n namespace.
+// It expects the armored signature file as Options.Signature and the []SSHAllowedSigner trusted
+// to sign updates as Options.PublicKey. The signature must have been made in the given namespace
+// by one of the allowed signers, for any of its principals.
+//
+// SSH signatures cover the checksum of the update, so Options.Hash must match the hash algorithm
+// of the signature: crypto.SHA512 for signatures made by ssh-keygen with its defaults.
+func NewSSHSigVerifier(namespace string) Verifier {
+	return verifyFn(func(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+		signers, ok := publicKey.([]SSHAllowedSigner)
+		if !ok {
+			return errors.New("not a valid list of allowed SSH signers")
+		}
+
+		sig, err := parseSSHSig(signature)
+		if err != nil {
+			return err
+		}
+		if sig.namespace != namespace {
+			return fmt.Errorf("SSH signature was made in namespace %q, not %q", sig.namespace, namespace)
+		}
+		if sshHashes[sig.hashAlg] != hash {
+			return fmt.Errorf("SSH signature was made with hash algorithm %s, not %v", sig.hashAlg, hash)
+		}
+
+		_, key, err := parseSSHPublicKey(sig.publicKey)
+		if err != nil {
+			return err
+		}
+		signer := findSSHSigner(signers, key, namespace)
+		if signer == nil {
+			return errors.New("SSH signature was not made by an allowed signer")
+		}
+
+		// the signature covers the namespace and the hash algorithm too
+		var signed bytes.Buffer
+		signed.WriteString(sshsigMagic)
+		writeSSHString(&signed, []byte(sig.namespace))
+		writeSSHString(&signed, sig.reserved)
+		writeSSHString(&signed, []byte(sig.hashAlg))
+		writeSSHString(&signed, checksum)
+		return verifySSHSignature(signer.PublicKey, signed.Bytes(), sig.signature)
+	})
+}
+
+var sshHashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha512": crypto.SHA512,
+}
+
+type sshSig struct {
+	publicKey []byte
+	namespace string
+	reserved  []byte
+	hashAlg   string
+	signature []byte
+}
+
+func parseSSHSig(armored []byte) (*sshSig, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return nil, errors.New("couldn't parse SSH signature")
+	}
+
+	r := sshReader(block.Bytes)
+	if !bytes.HasPrefix(r, []byte(sshsigMagic)) {
+		return nil, errors.New("not an SSH signature")
+	}
+	r = r[len(sshsigMagic):]
+
+	var sig sshSig
+	version := r.uint32()
+	pub := r.string()
+	namespace := r.string()
+	sig.reserved = r.string()
+	hashAlg := r.string()
+	sig.signature = r.string()
+	if r == nil {
+		return nil, errors.New("truncated SSH signature")
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", version)
+	}
+	sig.publicKey, sig.namespace, sig.hashAlg = pub, string(namespace), string(hashAlg)
+	return &sig, nil
+}
+
+// parseSSHPublicKey parses a public key in the SSH wire format.
+func parseSSHPublicKey(blob []byte) (string, crypto.PublicKey, error) {
+	r := sshReader(blob)
+	keyType := string(r.string())
+	switch keyType {
+	case "ssh-ed25519":
+		key := r.string()
+		if r == nil || len(key) != ed25519.PublicKeySize {
+			return "", nil, errors.New("invalid ssh-ed25519 key")
+		}
+		return keyType, ed25519.PublicKey(key), nil
+	case "ecdsa-sha2-nistp256":
+		curve := r.string()
+		point := r.string()
+		if r == nil || string(curve) != "nistp256" {
+			return "", nil, errors.New("invalid ecdsa-sha2-nistp256 key")
+		}
+		x, y := elliptic.Unmarshal(elliptic.P256(), point)
+		if x == nil {
+			return "", nil, errors.New("invalid ecdsa-sha2-nistp256 key")
+		}
+		return keyType, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return "", nil, fmt.Errorf("unsupported SSH key type %q", keyType)
+}
+
+func verifySSHSignature(key crypto.PublicKey, signed, blob []byte) error {
+	r := sshReader(blob)
+	format := string(r.string())
+	sig := r.string()
+	if r == nil {
+		return errors.New("invalid SSH signature blob")
+	}
+
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		if format != "ssh-ed25519" || !ed25519.Verify(key, signed, sig) {
+			return errors.New("failed to verify SSH ed25519 signature")
+		}
+	case *ecdsa.PublicKey:
+		rs := sshReader(sig)
+		r, s := new(big.Int).SetBytes(rs.string()), new(big.Int).SetBytes(rs.string())
+		digest := sha256.Sum256(signed)
+		if format != "ecdsa-sha2-nistp256" || rs == nil || !ecdsa.Verify(key, digest[:], r, s) {
+			return errors.New("failed to verify SSH ecdsa signature")
+		}
+	default:
+		return fmt.Errorf("unsupported SSH key type %T", key)
+	}
+	return nil
+}
+
+func findSSHSigner(signers []SSHAllowedSigner, key crypto.PublicKey, namespace string) *SSHAllowedSigner {
+	k, ok := key.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return nil
+	}
+	for i, signer := range signers {
+		if !k.Equal(signer.PublicKey) {
+			continue
+		}
+		if len(signer.Namespaces) == 0 {
+			return &signers[i]
+		}
+		for _, ns := range signer.Namespaces {
+			if ns == namespace {
+				return &signers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// sshReader reads values in the SSH wire format. It becomes nil once a read runs out of data.
+type sshReader []byte
+
+func (r *sshReader) uint32() uint32 {
+	if len(*r) < 4 {
+		*r = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(*r)
+	*r = (*r)[4:]
+	return v
+}
+
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if *r == nil || uint32(len(*r)) < n {
+		*r = nil
+		return nil
+	}
+	s := (*r)[:n]
+	*r = (*r)[n:]
+	return s
+}
+
+func writeSSHString(buf *bytes.Buffer, s []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	buf.Write(n[:])
+	buf.Write(s)
+}
+
+// splitQuoted splits s at spaces that are not inside double quotes.
+func splitQuoted(s string) []string {
+	var fields []string
+	var quoted bool
+	start := -1
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if start >= 0 {
+				fields = append(fields, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, s[start:])
+	}
+	return fields
+}
+
+// splitOptions splits s at commas that are not inside double quotes.
+func splitOptions(s string) []string {
+	var opts []string
+	var quoted bool
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			opts = append(opts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(opts, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
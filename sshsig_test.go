@@ -0,0 +1,113 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"testing"
+)
+
+// Signatures of newFile made with: ssh-keygen -Y sign -f key -n file
+const sshAllowedSigners = `# trusted update signers
+dev@example.com namespaces="file" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFY5M8gZhXbFn5lvlJtUFRcqwt7mzK9IEbY3oz+tObpL
+ops@example.com ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBHSvzTSGDXmR5AL5LRsEGUVu/o6rwEz/Cn4IdEKw7+SBcDTB1HPZs3/YUBFjsrnfCVmjr6ys5bvnTHg4vGY9WZA=
+`
+
+const sshEd25519Signature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgVjkzyBmFdsWfmW+Um1QVFyrC3u
+bMr0gRtjejP605uksAAAAEZmlsZQAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAEBxeiCmlU1PikYmKSjOKSVCknwx2ll99A3e8qzmom/pYvcFAak4inLHVr63rJbFAo
+/mLl7FVc9cSkBgupO/8jMN
+-----END SSH SIGNATURE-----
+`
+
+const sshECDSASignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAAGgAAAATZWNkc2Etc2hhMi1uaXN0cDI1NgAAAAhuaXN0cDI1NgAAAE
+EEdK/NNIYNeZHkAvktGwQZRW7+jqvATP8Kfgh0QrDv5IFwNMHUc9mzf9hQEWOyud8JWaOv
+rKzlu+dMeDi8Zj1ZkAAAAARmaWxlAAAAAAAAAAZzaGE1MTIAAABjAAAAE2VjZHNhLXNoYT
+ItbmlzdHAyNTYAAABIAAAAIDyaMNd8Xihd25HqlATng80lSm0L5TOBGbcVaNVk/KBVAAAA
+IGD/LLhsUioW+VLbGwkR2uZBDu1dBO65FD+yd/kaSWYE
+-----END SSH SIGNATURE-----
+`
+
+// made with -n other
+const sshOtherNamespaceSignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgVjkzyBmFdsWfmW+Um1QVFyrC3u
+bMr0gRtjejP605uksAAAAFb3RoZXIAAAAAAAAABnNoYTUxMgAAAFMAAAALc3NoLWVkMjU1
+MTkAAABAYaizGFZ6BybBly9xeAb68QKmdUkYkZ9THzMsKYAbcIn10nvBoOkgdLB+JOQFzY
+nKOeyUHuAs9XqSLpIK7eI2Cw==
+-----END SSH SIGNATURE-----
+`
+
+func TestParseSSHAllowedSigners(t *testing.T) {
+	signers, err := ParseSSHAllowedSigners([]byte(sshAllowedSigners))
+	if err != nil {
+		t.Fatalf("Could not parse allowed signers: %v", err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("Expected 2 allowed signers, got %d", len(signers))
+	}
+	if signers[0].Principals[0] != "dev@example.com" || len(signers[0].Namespaces) != 1 || signers[0].Namespaces[0] != "file" {
+		t.Fatalf("Parsed wrong principals or namespaces: %+v", signers[0])
+	}
+
+	_, err = ParseSSHAllowedSigners([]byte(`dev@example.com cert-authority ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFY5M8gZhXbFn5lvlJtUFRcqwt7mzK9IEbY3oz+tObpL`))
+	if err == nil {
+		t.Fatalf("Accepted an allowed signer with an unsupported option")
+	}
+}
+
+func TestVerifySSHSignature(t *testing.T) {
+	fName := "TestVerifySSHSignature"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	signers, err := ParseSSHAllowedSigners([]byte(sshAllowedSigners))
+	if err != nil {
+		t.Fatalf("Could not parse allowed signers: %v", err)
+	}
+
+	for _, sig := range []string{sshEd25519Signature, sshECDSASignature} {
+		writeOldFile(fName, t)
+		err = Apply(bytes.NewReader(newFile), Options{
+			TargetPath: fName,
+			Hash:       crypto.SHA512,
+			PublicKey:  signers,
+			Signature:  []byte(sig),
+			Verifier:   NewSSHSigVerifier("file"),
+		})
+		validateUpdate(fName, err, t)
+	}
+
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Hash:       crypto.SHA512,
+		PublicKey:  signers,
+		Signature:  []byte(sshOtherNamespaceSignature),
+		Verifier:   NewSSHSigVerifier("file"),
+	})
+	if err == nil {
+		t.Fatalf("Accepted an SSH signature from another namespace!")
+	}
+
+	// the ed25519 key is only allowed to sign in the file namespace
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Hash:       crypto.SHA512,
+		PublicKey:  signers,
+		Signature:  []byte(sshOtherNamespaceSignature),
+		Verifier:   NewSSHSigVerifier("other"),
+	})
+	if err == nil {
+		t.Fatalf("Accepted an SSH signature outside of the signer's namespaces!")
+	}
+
+	err = Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		PublicKey:  signers,
+		Signature:  []byte(sshEd25519Signature),
+		Verifier:   NewSSHSigVerifier("file"),
+	})
+	if err == nil {
+		t.Fatalf("Accepted an SHA512 SSH signature against an SHA256 checksum!")
+	}
+}
@@ -0,0 +1,159 @@
+package update
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// atomicSwap reports whether the target can be replaced by a single rename.
+// Windows refuses to rename over an existing file, let alone a running executable.
+func (o *Options) atomicSwap() bool {
+	return o.Swap == SwapAtomic && runtime.GOOS != "windows"
+}
+
+// swap replaces the target with the file at newPath using the configured strategy.
+// The replaced target is kept at oldPath unless removeOld is set.
+func (o *Options) swap(newPath, oldPath string, removeOld bool) error {
+	if o.atomicSwap() {
+		return o.swapAtomic(newPath, oldPath, !removeOld)
+	}
+
+	if err := o.swapRename(newPath, oldPath); err != nil {
+		return err
+	}
+
+	// move successful, remove the old binary if needed
+	if removeOld {
+		discardFile(oldPath)
+	}
+
+	return nil
+}
+
+// discardFile removes the replaced executable at path.
+func discardFile(path string) {
+	errRemove := os.Remove(path)
+
+	// windows has trouble with removing old binaries, so hide it instead
+	if errRemove != nil {
+		_ = hideFile(path)
+	}
+}
+
+// swapRename moves the target to oldPath and then moves newPath to the target.
+// If the second move fails, it tries to move oldPath back to the target.
+func (o *Options) swapRename(newPath, oldPath string) error {
+	targetDir := filepath.Dir(o.TargetPath)
+
+	// delete any existing old exec file - this is necessary on Windows for two reasons:
+	// 1. after a successful update, Windows can't remove the .old file because the process is still running
+	// 2. windows rename operations fail if the destination file already exists
+	_ = os.Remove(oldPath)
+
+	// move the existing executable to a new file in the same directory
+	err := os.Rename(o.TargetPath, oldPath)
+	if err != nil {
+		return err
+	}
+
+	// move the new exectuable in to become the new program
+	err = o.syncDirs(targetDir, filepath.Dir(oldPath))
+	if err == nil {
+		err = os.Rename(newPath, o.TargetPath)
+	}
+	if err == nil {
+		err = o.syncDirs(targetDir)
+	}
+
+	if err != nil {
+		// move unsuccessful
+		//
+		// The filesystem is now in a bad state. We have successfully
+		// moved the existing binary to a new location, but we couldn't move the new
+		// binary to take its place. That means there is no file where the current executable binary
+		// used to be!
+		// Try to rollback by restoring the old binary to its original path.
+		rerr := os.Rename(oldPath, o.TargetPath)
+		if rerr == nil {
+			rerr = o.syncDirs(targetDir, filepath.Dir(oldPath))
+		}
+		if rerr != nil {
+			return &rollbackErr{err, rerr}
+		}
+
+		return err
+	}
+	return nil
+}
+
+// swapAtomic preserves the target at oldPath if saveOld is set and then renames
+// newPath over the target. The target path never stops resolving to a complete file.
+func (o *Options) swapAtomic(newPath, oldPath string, saveOld bool) error {
+	targetDir := filepath.Dir(o.TargetPath)
+
+	// an old file from a previous update is of no use anymore
+	_ = os.Remove(oldPath)
+
+	if saveOld {
+		if err := os.Link(o.TargetPath, oldPath); err != nil {
+			// e.g. OldSavePath is on another file system or links aren't supported
+			if err = copyFile(o.TargetPath, oldPath, o.Durability == DurabilitySync); err != nil {
+				return err
+			}
+		}
+		if err := o.syncDirs(filepath.Dir(oldPath)); err != nil {
+			_ = os.Remove(oldPath)
+			return err
+		}
+	}
+
+	if err := os.Rename(newPath, o.TargetPath); err != nil {
+		if saveOld {
+			_ = os.Remove(oldPath)
+		}
+		return err
+	}
+
+	// the update is live now, so the saved target is what's left to roll back to
+	if err := o.syncDirs(targetDir); err != nil {
+		return &DurabilityError{err}
+	}
+	return nil
+}
+
+// copyFile copies the contents and permission bits of src to a new file at dst.
+func copyFile(src, dst string, sync bool) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := openFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if sync {
+		if err = out.Sync(); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,133 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// SymlinkPolicy selects what Apply does when the target is a symbolic link.
+type SymlinkPolicy int
+
+const (
+	// SymlinkReplace replaces the link itself with a regular file.
+	SymlinkReplace SymlinkPolicy = iota
+
+	// SymlinkFollow resolves the link and replaces the file it points to, leaving the link intact.
+	SymlinkFollow
+
+	// SymlinkRetarget installs the new version at Options.LinkDest, next to the current one,
+	// and then atomically repoints the link at it. The version the link pointed to before is
+	// left in place, so switching back to it only takes repointing the link. This is not
+	// supported on Windows.
+	SymlinkRetarget
+)
+
+// retarget installs the update at LinkDest and points the link at TargetPath to it.
+func (o *Options) retarget(ctx context.Context, update io.Reader, verify bool) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("retargeting symbolic links is not supported on Windows")
+	}
+	if o.LinkDest == "" {
+		return errors.New("no LinkDest to install the new version at")
+	}
+
+	info, err := os.Lstat(o.TargetPath)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s is not a symbolic link", o.TargetPath)
+	}
+
+	linkDir := filepath.Dir(o.TargetPath)
+	dest := o.LinkDest
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(linkDir, dest)
+	}
+
+	// replacing the file the link points to would defeat the purpose
+	current, err := filepath.EvalSymlinks(o.TargetPath)
+	if err != nil {
+		return err
+	}
+	if same, _ := sameFile(current, dest); same {
+		return fmt.Errorf("%s is the current version", dest)
+	}
+
+	destDir := filepath.Dir(dest)
+	if err = os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	newPath := filepath.Join(destDir, fmt.Sprintf(".%s.new", filepath.Base(dest)))
+	if err = o.prepare(ctx, update, newPath, verify); err != nil {
+		return err
+	}
+
+	err = os.Rename(newPath, dest)
+	if err == nil {
+		err = o.syncDirs(destDir)
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+
+	prevDest, err := os.Readlink(o.TargetPath)
+	if err != nil {
+		return err
+	}
+
+	// keep relative links relative so the whole tree can be moved around
+	linkDest := dest
+	if !filepath.IsAbs(prevDest) {
+		if rel, err := filepath.Rel(linkDir, dest); err == nil {
+			linkDest = rel
+		}
+	}
+	if err = o.replaceSymlink(o.TargetPath, linkDest); err != nil {
+		return err
+	}
+
+	return o.checkHealth(o.TargetPath, func() error {
+		if err := o.replaceSymlink(o.TargetPath, prevDest); err != nil {
+			return err
+		}
+		_ = os.Remove(dest)
+		return nil
+	})
+}
+
+// replaceSymlink atomically replaces (or creates) the symbolic link at link so that it points to dest.
+func (o *Options) replaceSymlink(link, dest string) error {
+	linkDir := filepath.Dir(link)
+	newLink := filepath.Join(linkDir, fmt.Sprintf(".%s.new", filepath.Base(link)))
+
+	_ = os.Remove(newLink)
+	if err := os.Symlink(dest, newLink); err != nil {
+		return err
+	}
+	if err := os.Rename(newLink, link); err != nil {
+		_ = os.Remove(newLink)
+		return err
+	}
+	return o.syncDirs(linkDir)
+}
+
+// sameFile reports whether the paths a and b refer to the same existing file.
+func sameFile(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(ai, bi), nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package update
+
+import "os"
+
+// syncDir flushes the directory entry changes (creations and renames) in dir to stable storage.
+func syncDir(dir string) error {
+	fp, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return fp.Sync()
+}
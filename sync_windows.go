@@ -0,0 +1,7 @@
+package update
+
+// syncDir is a no-op on Windows: directories can't be opened for flushing and NTFS
+// journals metadata operations such as renames.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package update
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// transitionPrefix separates signatures of transition statements from signatures of updates.
+const transitionPrefix = "go-update key transition\x00"
+
+// KeyTransition is a statement by a trusted key that authorizes a successor key to sign updates.
+// Transitions can be chained: a successor can authorize a successor of its own. This allows rotating
+// the keys that sign updates without having to first ship a release that trusts the new key.
+//
+// The predecessor stays trusted after a transition. To stop trusting a key, e.g. because it was
+// compromised, list its ID in Options.RevokedKeys. Successors count towards Options.Threshold as the
+// key of the Keyring their chain starts from, so a key holder can't meet a threshold on their own
+// by authorizing more keys.
+type KeyTransition struct {
+	// SignerID is the ID of the trusted key that signed the statement.
+	SignerID string
+
+	// Successor is the key being authorized. Its ID must not be in use by another trusted key.
+	// Its Verifier must be nil, since the signer chooses how its signatures are verified with Algorithm.
+	Successor TrustedKey
+
+	// Algorithm names the algorithm the successor's signatures are verified with: "rsa" (PKCS #1 v1.5),
+	// "rsa-pss", "ecdsa", "ed25519", "dsa", "minisign", "signify" or "sshsig:" followed by the namespace.
+	// If empty, Options.Verifier is used.
+	Algorithm string
+
+	// Expires is when the authorization lapses. The zero time means never.
+	Expires time.Time
+
+	// Signature is the signature of the digest returned by Digest, made with the SignerID key.
+	Signature []byte
+}
+
+// Digest returns the digest of the statement that the signer must sign, computed with the hash h
+// (Options.Hash when verified by Apply). It covers the signer ID, the successor's ID and public key,
+// the algorithm and the expiry time. The successor's public key must be supported by
+// x509.MarshalPKIXPublicKey.
+func (t *KeyTransition) Digest(h crypto.Hash) ([]byte, error) {
+	if !h.Available() {
+		return nil, errors.New("requested hash function not available")
+	}
+	if t.Successor.Verifier != nil {
+		return nil, errors.New("successor's verifier is not covered by the signature, set Algorithm instead")
+	}
+	if _, err := algorithmVerifier(t.Algorithm); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(t.Successor.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var expires int64
+	if !t.Expires.IsZero() {
+		expires = t.Expires.Unix()
+	}
+
+	hash := h.New()
+	hash.Write([]byte(transitionPrefix))
+	for _, field := range [][]byte{[]byte(t.SignerID), []byte(t.Successor.ID), der, []byte(t.Algorithm)} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(field)))
+		hash.Write(n[:])
+		hash.Write(field)
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(expires))
+	hash.Write(buf[:])
+	return hash.Sum(nil), nil
+}
+
+// algorithmVerifier returns the Verifier for the algorithm named by KeyTransition.Algorithm,
+// or nil if it's empty.
+func algorithmVerifier(algorithm string) (Verifier, error) {
+	switch algorithm {
+	case "":
+		return nil, nil
+	case "rsa":
+		return NewRSAVerifier(), nil
+	case "rsa-pss":
+		return NewRSAPSSVerifier(nil), nil
+	case "ecdsa":
+		return NewECDSAVerifier(), nil
+	case "ed25519":
+		return NewEd25519Verifier(), nil
+	case "dsa":
+		return NewDSAVerifier(), nil
+	case "minisign":
+		return NewMinisignVerifier(), nil
+	case "signify":
+		return NewSignifyVerifier(), nil
+	}
+	if namespace := strings.TrimPrefix(algorithm, "sshsig:"); namespace != algorithm && namespace != "" {
+		return NewSSHSigVerifier(namespace), nil
+	}
+	return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+}
+
+// trustedKey is a key trusted to sign updates along with the ID of the key of the Keyring
+// whose chain of transitions it was authorized by.
+type trustedKey struct {
+	TrustedKey
+	root string
+}
+
+// trustedKeys returns the keys of the Keyring that haven't been revoked, along with all
+// successors authorized by a chain of valid transition statements leading back to them.
+// Statements that are expired, signed by a revoked or untrusted key, name a revoked successor,
+// reuse a trusted key or ID or don't verify are ignored.
+func (o *Options) trustedKeys(now time.Time) []trustedKey {
+	revoked := make(map[string]bool, len(o.RevokedKeys))
+	for _, id := range o.RevokedKeys {
+		revoked[id] = true
+	}
+
+	var trusted []trustedKey
+	byID := make(map[string]trustedKey)
+	for _, key := range o.Keyring {
+		if !revoked[key.ID] {
+			tk := trustedKey{key, key.ID}
+			trusted = append(trusted, tk)
+			byID[key.ID] = tk
+		}
+	}
+
+	// a statement can only be checked once its signer is trusted, so repeat
+	// until no more successors are found
+	applied := make([]bool, len(o.Transitions))
+	for found := true; found; {
+		found = false
+		for i, t := range o.Transitions {
+			if applied[i] {
+				continue
+			}
+			signer, ok := byID[t.SignerID]
+			if !ok {
+				continue
+			}
+			applied[i] = true
+			if !o.validTransition(t, signer, byID, revoked, now) {
+				continue
+			}
+			successor := t.Successor
+			successor.Verifier, _ = algorithmVerifier(t.Algorithm)
+			tk := trustedKey{successor, signer.root}
+			trusted = append(trusted, tk)
+			byID[t.Successor.ID] = tk
+			found = true
+		}
+	}
+	return trusted
+}
+
+func (o *Options) validTransition(t KeyTransition, signer trustedKey, byID map[string]trustedKey, revoked map[string]bool, now time.Time) bool {
+	if !t.Expires.IsZero() && !now.Before(t.Expires) {
+		return false
+	}
+	if t.Successor.PublicKey == nil || revoked[t.Successor.ID] {
+		return false
+	}
+	if _, ok := byID[t.Successor.ID]; ok {
+		return false
+	}
+	// the same key under another ID would count twice towards the threshold
+	if k, ok := t.Successor.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		for _, key := range byID {
+			if k.Equal(key.PublicKey) {
+				return false
+			}
+		}
+	}
+
+	digest, err := t.Digest(o.Hash)
+	if err != nil {
+		return false
+	}
+	v := signer.Verifier
+	if v == nil {
+		v = o.Verifier
+	}
+	return v.VerifySignature(digest, t.Signature, o.Hash, signer.PublicKey) == nil
+}
@@ -0,0 +1,215 @@
+package update
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Validator defines an interface for checking the new file before it replaces the target.
+type Validator interface {
+	// Validate inspects the new file at path, which is about to replace the file at target.
+	// The target may not exist, e.g. for the first install of a VersionedInstaller.
+	Validate(path, target string) error
+}
+
+type validateFn func(string, string) error
+
+func (fn validateFn) Validate(path, target string) error {
+	return fn(path, target)
+}
+
+// ExecutableFormat describes the platform an executable file was built for.
+type ExecutableFormat struct {
+	// Format is the executable file format: "elf", "macho" or "pe".
+	Format string
+
+	// Arch is the processor architecture, named like GOARCH.
+	Arch string
+
+	// Bits is 32 or 64.
+	Bits int
+}
+
+func (f ExecutableFormat) String() string {
+	return fmt.Sprintf("%s/%s (%d-bit)", f.Format, f.Arch, f.Bits)
+}
+
+// ExecutableError is returned by the Validator from NewExecutableValidator when it rejects an update.
+type ExecutableError struct {
+	// Want is the format of the target, or of the running platform if the target isn't an executable.
+	Want ExecutableFormat
+
+	// Got is the format of the update. It's zero if the update isn't a recognized executable.
+	Got ExecutableFormat
+}
+
+func (e *ExecutableError) Error() string {
+	if e.Got.Format == "" {
+		return fmt.Sprintf("update is not an executable, want %v", e.Want)
+	}
+	return fmt.Sprintf("update is an executable for the wrong platform, want %v, got %v", e.Want, e.Got)
+}
+
+// NewExecutableValidator returns a Validator that accepts the new file only if it's an executable
+// of the same format, architecture and bitness as the target. If the target isn't a recognized
+// executable, the new file must match runtime.GOOS and runtime.GOARCH instead. Mach-O universal
+// binaries are accepted if one of their architectures matches.
+func NewExecutableValidator() Validator {
+	return validateFn(func(path, target string) error {
+		want, err := executableFormats(target)
+		if err != nil {
+			if want, err = runtimeFormat(); err != nil {
+				return err
+			}
+		}
+
+		got, err := executableFormats(path)
+		if err != nil {
+			return &ExecutableError{Want: want[0]}
+		}
+		for _, w := range want {
+			for _, g := range got {
+				if w == g {
+					return nil
+				}
+			}
+		}
+		return &ExecutableError{Want: want[0], Got: got[0]}
+	})
+}
+
+// executableFormats returns the formats of the executable at path. Only universal binaries have more than one.
+func executableFormats(path string) ([]ExecutableFormat, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return []ExecutableFormat{elfFormat(f)}, nil
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return []ExecutableFormat{machoFormat(f.Cpu, f.Magic)}, nil
+	}
+	if f, err := macho.OpenFat(path); err == nil {
+		defer f.Close()
+		formats := make([]ExecutableFormat, len(f.Arches))
+		for i, arch := range f.Arches {
+			formats[i] = machoFormat(arch.Cpu, arch.Magic)
+		}
+		return formats, nil
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		return []ExecutableFormat{peFormat(f)}, nil
+	}
+	return nil, errors.New("not a recognized executable")
+}
+
+func runtimeFormat() ([]ExecutableFormat, error) {
+	format := "elf"
+	switch runtime.GOOS {
+	case "windows":
+		format = "pe"
+	case "darwin", "ios":
+		format = "macho"
+	case "plan9", "js", "wasip1":
+		return nil, fmt.Errorf("validating executables is not supported on %s", runtime.GOOS)
+	}
+
+	bits := 32
+	switch runtime.GOARCH {
+	case "amd64", "arm64", "loong64", "mips64", "mips64le", "ppc64", "ppc64le", "riscv64", "s390x":
+		bits = 64
+	}
+	return []ExecutableFormat{{Format: format, Arch: runtime.GOARCH, Bits: bits}}, nil
+}
+
+func elfFormat(f *elf.File) ExecutableFormat {
+	bits := 32
+	if f.Class == elf.ELFCLASS64 {
+		bits = 64
+	}
+	le := f.Data == elf.ELFDATA2LSB
+
+	var arch string
+	switch f.Machine {
+	case elf.EM_386:
+		arch = "386"
+	case elf.EM_X86_64:
+		arch = "amd64"
+	case elf.EM_ARM:
+		arch = "arm"
+	case elf.EM_AARCH64:
+		arch = "arm64"
+	case elf.EM_LOONGARCH:
+		arch = "loong64"
+	case elf.EM_MIPS:
+		arch = "mips"
+		if bits == 64 {
+			arch = "mips64"
+		}
+		if le {
+			arch += "le"
+		}
+	case elf.EM_PPC64:
+		arch = "ppc64"
+		if le {
+			arch = "ppc64le"
+		}
+	case elf.EM_RISCV:
+		arch = "riscv64"
+	case elf.EM_S390:
+		arch = "s390x"
+	default:
+		arch = f.Machine.String()
+	}
+	return ExecutableFormat{Format: "elf", Arch: arch, Bits: bits}
+}
+
+func machoFormat(cpu macho.Cpu, magic uint32) ExecutableFormat {
+	bits := 32
+	if magic == macho.Magic64 {
+		bits = 64
+	}
+
+	var arch string
+	switch cpu {
+	case macho.Cpu386:
+		arch = "386"
+	case macho.CpuAmd64:
+		arch = "amd64"
+	case macho.CpuArm:
+		arch = "arm"
+	case macho.CpuArm64:
+		arch = "arm64"
+	case macho.CpuPpc64:
+		arch = "ppc64"
+	default:
+		arch = cpu.String()
+	}
+	return ExecutableFormat{Format: "macho", Arch: arch, Bits: bits}
+}
+
+func peFormat(f *pe.File) ExecutableFormat {
+	bits := 32
+	if _, ok := f.OptionalHeader.(*pe.OptionalHeader64); ok {
+		bits = 64
+	}
+
+	var arch string
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		arch = "386"
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		arch = "amd64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		arch = "arm"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		arch = "arm64"
+	default:
+		arch = fmt.Sprintf("machine(%#x)", f.Machine)
+	}
+	return ExecutableFormat{Format: "pe", Arch: arch, Bits: bits}
+}
@@ -0,0 +1,45 @@
+package update
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestExecutableValidator(t *testing.T) {
+	fName := "TestExecutableValidator"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	// the test binary itself is an executable for the running platform
+	exe, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to open test executable: %v", err)
+	}
+	defer exe.Close()
+
+	err = Apply(exe, Options{
+		TargetPath: fName,
+		Validator:  NewExecutableValidator(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to update with a valid executable: %v", err)
+	}
+}
+
+func TestExecutableValidatorNegative(t *testing.T) {
+	fName := "TestExecutableValidatorNegative"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	err := Apply(bytes.NewReader(newFile), Options{
+		TargetPath: fName,
+		Validator:  NewExecutableValidator(),
+	})
+	if _, ok := err.(*ExecutableError); !ok {
+		t.Fatalf("Expected an *ExecutableError, got: %v", err)
+	}
+	if _, err := os.Stat(".TestExecutableValidatorNegative.new"); !os.IsNotExist(err) {
+		t.Fatalf("Staged file was not removed after failed validation: %v", err)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/asn1"
 	"errors"
+	"fmt"
+	"io"
 	"math/big"
 )
 
@@ -15,6 +18,15 @@ type Verifier interface {
 	VerifySignature(checksum, signature []byte, h crypto.Hash, publicKey crypto.PublicKey) error
 }
 
+// PayloadVerifier is implemented by Verifiers for signature formats that cover the update itself
+// rather than its checksum. Apply calls VerifyPayload instead of VerifySignature for them, with
+// the complete new file as payload. Such signatures can't be combined with Options.Version, and
+// are verified with VerifySignature when used in a keyring.
+type PayloadVerifier interface {
+	Verifier
+	VerifyPayload(payload io.Reader, signature []byte, publicKey crypto.PublicKey) error
+}
+
 type verifyFn func([]byte, []byte, crypto.Hash, crypto.PublicKey) error
 
 func (fn verifyFn) VerifySignature(checksum []byte, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
@@ -32,6 +44,22 @@ func NewRSAVerifier() Verifier {
 	})
 }
 
+// NewRSAPSSVerifier returns a Verifier that uses the RSA algorithm with the PSS signature
+// scheme to verify updates. If opts is nil, signatures with any salt length are accepted.
+// Set opts.SaltLength to rsa.PSSSaltLengthEqualsHash to require the recommended salt length.
+func NewRSAPSSVerifier(opts *rsa.PSSOptions) Verifier {
+	if opts == nil {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+	}
+	return verifyFn(func(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+		key, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("not a valid RSA public key")
+		}
+		return rsa.VerifyPSS(key, hash, checksum, signature, opts)
+	})
+}
+
 type rsDER struct {
 	R *big.Int
 	S *big.Int
@@ -72,3 +100,55 @@ func NewDSAVerifier() Verifier {
 		return nil
 	})
 }
+
+// NewEd25519Verifier returns a Verifier that uses the Ed25519 algorithm to verify updates.
+//
+// Like all Verifiers, it verifies a signature of the checksum of the update, i.e. of its digest
+// computed with Options.Hash, not of the update itself. With OpenSSL, such a signature is
+// made by signing the raw digest:
+//
+//	openssl dgst -sha256 -binary update > update.sha256
+//	openssl pkeyutl -sign -rawin -inkey private.pem -in update.sha256 -out update.sig
+//
+// Signatures made with ssh-keygen -Y sign are not compatible: they are SSHSIG signatures, which
+// sign the checksum along with a namespace and framing of their own. Verify them with
+// NewSSHSigVerifier instead.
+func NewEd25519Verifier() Verifier {
+	return verifyFn(func(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+		key, ok := publicKey.(ed25519.PublicKey)
+		if !ok || len(key) != ed25519.PublicKeySize {
+			return errors.New("not a valid Ed25519 public key")
+		}
+		if !ed25519.Verify(key, checksum, signature) {
+			return errors.New("failed to verify ed25519 signature")
+		}
+		return nil
+	})
+}
+
+// NewAutoVerifier returns a Verifier that picks the algorithm to verify updates with
+// from the type of the public key: *rsa.PublicKey (PKCS #1 v1.5, see NewRSAVerifier),
+// *ecdsa.PublicKey, ed25519.PublicKey or *dsa.PublicKey. RSA-PSS signatures require
+// NewRSAPSSVerifier, since the key type doesn't tell the signature schemes apart.
+func NewAutoVerifier() Verifier {
+	rsaVerifier := NewRSAVerifier()
+	ecdsaVerifier := NewECDSAVerifier()
+	ed25519Verifier := NewEd25519Verifier()
+	dsaVerifier := NewDSAVerifier()
+	return verifyFn(func(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+		var v Verifier
+		switch publicKey.(type) {
+		case *rsa.PublicKey:
+			v = rsaVerifier
+		case *ecdsa.PublicKey:
+			v = ecdsaVerifier
+		case ed25519.PublicKey:
+			v = ed25519Verifier
+		case *dsa.PublicKey:
+			v = dsaVerifier
+		default:
+			return fmt.Errorf("unsupported public key type %T", publicKey)
+		}
+		return v.VerifySignature(checksum, signature, hash, publicKey)
+	})
+}
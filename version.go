@@ -0,0 +1,109 @@
+package update
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// versionPrefix separates signatures over versioned checksums from signatures over plain checksums.
+const versionPrefix = "go-update versioned checksum\x00"
+
+// DowngradeError is returned when an update is rejected because its Version is lower than
+// the highest version ever installed at the target.
+type DowngradeError struct {
+	// Version is the version of the update. It's zero if the update didn't have one.
+	Version uint64
+
+	// Floor is the highest version ever installed at the target.
+	Floor uint64
+}
+
+func (e *DowngradeError) Error() string {
+	if e.Version == 0 {
+		return fmt.Sprintf("update without a version would replace version %d", e.Floor)
+	}
+	return fmt.Sprintf("update to version %d would downgrade version %d", e.Version, e.Floor)
+}
+
+// VersionedChecksum returns the digest an update issuer must sign for an update with the
+// given checksum and Options.Version: the hash h of a fixed prefix, the version as 8 big-endian
+// bytes and the checksum. Binding the version into the signature is what prevents an attacker
+// from replaying an older, validly signed update with a forged higher version.
+func VersionedChecksum(h crypto.Hash, checksum []byte, version uint64) ([]byte, error) {
+	if !h.Available() {
+		return nil, errors.New("requested hash function not available")
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], version)
+
+	hash := h.New()
+	hash.Write([]byte(versionPrefix))
+	hash.Write(buf[:])
+	hash.Write(checksum)
+	return hash.Sum(nil), nil
+}
+
+// versionFloorPath returns the path of the file recording the highest version ever installed at target.
+func versionFloorPath(target string) string {
+	return filepath.Join(filepath.Dir(target), fmt.Sprintf(".%s.version", filepath.Base(target)))
+}
+
+// checkVersionFloor returns the highest version ever installed as recorded at path, and an
+// error if installing the update would go below it.
+func (o *Options) checkVersionFloor(path string) (uint64, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	floor, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt version file %s: %v", path, err)
+	}
+
+	if o.Version < floor && !o.AllowDowngrade {
+		return floor, &DowngradeError{Version: o.Version, Floor: floor}
+	}
+	return floor, nil
+}
+
+// raiseVersionFloor records the update's version at path if it's higher than floor.
+func (o *Options) raiseVersionFloor(path string, floor uint64) error {
+	if o.Version <= floor {
+		return nil
+	}
+
+	newPath := path + ".new"
+	fp, err := openFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(fp, "%d\n", o.Version)
+	if err == nil && o.Durability == DurabilitySync {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		_ = os.Remove(path) // windows can't rename over an existing file
+		err = os.Rename(newPath, path)
+	}
+	if err == nil {
+		err = o.syncDirs(filepath.Dir(path))
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+		return fmt.Errorf("update installed, but failed to record its version: %v", err)
+	}
+	return nil
+}
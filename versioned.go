@@ -0,0 +1,310 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// VersionedInstaller installs each update side by side with the previous ones instead of
+// replacing a file in place. Every version lives in its own directory:
+//
+//	<Root>/versions/<version>/<Name>
+//	<Root>/current -> versions/<version>
+//
+// The order the versions were installed or switched to is recorded in <Root>/.<Name>.order,
+// one version per line, least recent first.
+//
+// Installing a version atomically switches the current link to it, and rolling back is
+// just switching the link back to an older version that's still installed. Programs should
+// be run through the link, i.e. from <Root>/current/<Name>, see Path.
+//
+// VersionedInstaller relies on symbolic links and is not supported on Windows.
+type VersionedInstaller struct {
+	// Root is the directory containing the versions directory and the current link.
+	Root string
+
+	// Name is the file name of the executable within each version directory.
+	Name string
+
+	// Keep is the number of versions to keep installed, including the current one.
+	// The versions least recently installed or switched to are removed after a successful
+	// install, except for the one that was current before it if Keep is at least 2.
+	// If zero, all versions are kept.
+	Keep int
+}
+
+// Path returns the path of the executable of the current version.
+func (vi *VersionedInstaller) Path() string {
+	return filepath.Join(vi.Root, "current", vi.Name)
+}
+
+// Install installs the update as the given version and makes it the current one.
+// See InstallContext.
+func (vi *VersionedInstaller) Install(update io.Reader, version string, opts Options) error {
+	return vi.InstallContext(context.Background(), update, version, opts)
+}
+
+// InstallContext writes the update to <Root>/versions/<version>/<Name>, verifying it exactly
+// like ApplyContext does, and then makes it the current version. The update must be a new version,
+// use Switch to make an installed version the current one. A patch is applied against the current
+// version, and TargetMode, PreserveAttributes, HealthCheck and Version apply as well. If the health check fails,
+// the previous version becomes the current one again and the new version is removed.
+// The highest Version ever installed is recorded in <Root>/.<Name>.version. The TargetPath, OldSavePath, Swap,
+// Symlink and LinkDest options are ignored.
+func (vi *VersionedInstaller) InstallContext(ctx context.Context, update io.Reader, version string, opts Options) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("versioned installs are not supported on Windows")
+	}
+	if err := checkVersionName(version); err != nil {
+		return err
+	}
+
+	verify, err := opts.setup()
+	if err != nil {
+		return err
+	}
+	opts.TargetPath = vi.Path()
+
+	floorPath := filepath.Join(vi.Root, fmt.Sprintf(".%s.version", vi.Name))
+	floor, err := opts.checkVersionFloor(floorPath)
+	if err != nil {
+		return err
+	}
+
+	prev, err := vi.Current()
+	if err != nil {
+		return err
+	}
+
+	versionDir := vi.versionDir(version)
+	if _, err = os.Lstat(versionDir); err == nil {
+		return fmt.Errorf("version %s is already installed", version)
+	}
+	if err = os.MkdirAll(versionDir, 0755); err != nil {
+		return err
+	}
+
+	newPath := filepath.Join(versionDir, fmt.Sprintf(".%s.new", vi.Name))
+	if err = opts.prepare(ctx, update, newPath, verify); err != nil {
+		_ = os.RemoveAll(versionDir)
+		return err
+	}
+
+	err = os.Rename(newPath, filepath.Join(versionDir, vi.Name))
+	if err == nil {
+		err = opts.syncDirs(versionDir)
+	}
+	if err == nil {
+		err = opts.replaceSymlink(vi.currentLink(), filepath.Join("versions", version))
+	}
+	if err != nil {
+		_ = os.RemoveAll(versionDir)
+		return err
+	}
+
+	err = opts.checkHealth(vi.Path(), func() error {
+		var err error
+		if prev == "" {
+			err = os.Remove(vi.currentLink())
+		} else {
+			err = opts.replaceSymlink(vi.currentLink(), filepath.Join("versions", prev))
+		}
+		if err != nil {
+			return err
+		}
+		_ = os.RemoveAll(versionDir)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = vi.markUsed(version, &opts); err != nil {
+		return fmt.Errorf("update installed, but failed to record the install order: %v", err)
+	}
+	vi.prune(prev, &opts)
+	return opts.raiseVersionFloor(floorPath, floor)
+}
+
+// Current returns the current version, or the empty string if no version is installed.
+func (vi *VersionedInstaller) Current() (string, error) {
+	dest, err := os.Readlink(vi.currentLink())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(dest), nil
+}
+
+// Versions returns the installed versions in the order they were installed or last switched to,
+// least recent first. Installed versions missing from the order file, e.g. because recording the
+// order failed, are listed first, sorted by name.
+func (vi *VersionedInstaller) Versions() ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(vi.Root, "versions"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	order, err := vi.readOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	installed := make(map[string]bool)
+	for _, info := range infos {
+		if info.IsDir() {
+			installed[info.Name()] = true
+		}
+	}
+
+	var versions, unordered []string
+	for _, version := range order {
+		if installed[version] {
+			versions = append(versions, version)
+			delete(installed, version)
+		}
+	}
+	for version := range installed {
+		unordered = append(unordered, version)
+	}
+	sort.Strings(unordered)
+	return append(unordered, versions...), nil
+}
+
+// Switch atomically makes the installed version the current one. Rolling back an
+// update is switching to the version that was current before it.
+func (vi *VersionedInstaller) Switch(version string) error {
+	if err := checkVersionName(version); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(vi.versionDir(version), vi.Name)); err != nil {
+		return fmt.Errorf("version %s is not installed: %v", version, err)
+	}
+	o := Options{}
+	if err := o.replaceSymlink(vi.currentLink(), filepath.Join("versions", version)); err != nil {
+		return err
+	}
+	if err := vi.markUsed(version, &o); err != nil {
+		return fmt.Errorf("version switched, but failed to record it: %v", err)
+	}
+	return nil
+}
+
+// prune removes the least recently used versions beyond Keep. The current version is never
+// removed, and neither is prev, the version it replaced, unless only one version is kept.
+// Failures are ignored: a version that's left behind is removed by the next install.
+func (vi *VersionedInstaller) prune(prev string, o *Options) {
+	if vi.Keep <= 0 {
+		return
+	}
+	versions, err := vi.Versions()
+	if err != nil {
+		return
+	}
+	current, err := vi.Current()
+	if err != nil {
+		return
+	}
+
+	excess := len(versions) - vi.Keep
+	kept := versions[:0]
+	for _, version := range versions {
+		if excess > 0 && version != current && (version != prev || vi.Keep == 1) {
+			if os.RemoveAll(vi.versionDir(version)) == nil {
+				excess--
+				continue
+			}
+		}
+		kept = append(kept, version)
+	}
+	_ = vi.writeOrder(kept, o)
+}
+
+// markUsed moves version to the end of the order file, as the most recently used one.
+func (vi *VersionedInstaller) markUsed(version string, o *Options) error {
+	versions, err := vi.Versions()
+	if err != nil {
+		return err
+	}
+	order := versions[:0]
+	for _, v := range versions {
+		if v != version {
+			order = append(order, v)
+		}
+	}
+	return vi.writeOrder(append(order, version), o)
+}
+
+func (vi *VersionedInstaller) readOrder() ([]string, error) {
+	data, err := ioutil.ReadFile(vi.orderPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// writeOrder atomically replaces the order file with versions.
+func (vi *VersionedInstaller) writeOrder(versions []string, o *Options) error {
+	path := vi.orderPath()
+	newPath := path + ".new"
+	fp, err := openFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if _, err = fmt.Fprintln(fp, version); err != nil {
+			break
+		}
+	}
+	if err == nil && o.Durability == DurabilitySync {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(newPath, path)
+	}
+	if err == nil {
+		err = o.syncDirs(vi.Root)
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+	}
+	return err
+}
+
+func (vi *VersionedInstaller) versionDir(version string) string {
+	return filepath.Join(vi.Root, "versions", version)
+}
+
+func (vi *VersionedInstaller) orderPath() string {
+	return filepath.Join(vi.Root, fmt.Sprintf(".%s.order", vi.Name))
+}
+
+func (vi *VersionedInstaller) currentLink() string {
+	return filepath.Join(vi.Root, "current")
+}
+
+// checkVersionName rejects versions that can't safely be used as a directory name.
+func checkVersionName(version string) error {
+	if version == "" || version == "." || version == ".." || strings.ContainsAny(version, `/\`) {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package update
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestVersionedInstaller(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("versioned installs are not supported on Windows")
+	}
+	root := "TestVersionedInstaller"
+	defer os.RemoveAll(root)
+
+	vi := &VersionedInstaller{Root: root, Name: "tool", Keep: 2}
+	for _, version := range []string{"1.0", "1.1", "1.2"} {
+		if err := vi.Install(bytes.NewReader(newFile), version, Options{}); err != nil {
+			t.Fatalf("Failed to install version %s: %v", version, err)
+		}
+	}
+	validateUpdate(vi.Path(), nil, t)
+
+	versions, err := vi.Versions()
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0] != "1.1" || versions[1] != "1.2" {
+		t.Fatalf("Old versions were not pruned! Installed versions: %v", versions)
+	}
+
+	if err := vi.Switch("1.1"); err != nil {
+		t.Fatalf("Failed to switch version: %v", err)
+	}
+	if current, err := vi.Current(); err != nil || current != "1.1" {
+		t.Fatalf("Version was not switched! Current version: %s, error: %v", current, err)
+	}
+
+	err = vi.Install(bytes.NewReader(newFile), "1.3", Options{Checksum: []byte{0x0A, 0x0B, 0x0C, 0xFF}})
+	if err == nil {
+		t.Fatalf("Failed to detect bad checksum!")
+	}
+	if _, err := os.Stat(vi.versionDir("1.3")); !os.IsNotExist(err) {
+		t.Fatalf("Version directory was not removed after failed verification: %v", err)
+	}
+	if current, _ := vi.Current(); current != "1.1" {
+		t.Fatalf("Failed install changed the current version to %s", current)
+	}
+
+	// the version switched to is the one to roll back to, so 1.2 is pruned instead
+	if err := vi.Install(bytes.NewReader(newFile), "1.3", Options{}); err != nil {
+		t.Fatalf("Failed to install version 1.3: %v", err)
+	}
+	versions, err = vi.Versions()
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0] != "1.1" || versions[1] != "1.3" {
+		t.Fatalf("Pruned the previous version! Installed versions: %v", versions)
+	}
+}
+
+func TestVersionedInstallerBrokenLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("versioned installs are not supported on Windows")
+	}
+	root := "TestVersionedInstallerBrokenLink"
+	defer os.RemoveAll(root)
+
+	// current is not a link, so the current version can't be determined
+	vi := &VersionedInstaller{Root: root, Name: "tool"}
+	if err := os.MkdirAll(vi.currentLink(), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := vi.Install(bytes.NewReader(newFile), "1.0", Options{}); err == nil {
+		t.Fatalf("Installed a version without knowing the current one")
+	}
+	if _, err := os.Stat(vi.versionDir("1.0")); !os.IsNotExist(err) {
+		t.Fatalf("Failed install left the version directory behind: %v", err)
+	}
+
+	if err := os.Remove(vi.currentLink()); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	if err := vi.Install(bytes.NewReader(newFile), "1.0", Options{}); err != nil {
+		t.Fatalf("Failed to install version 1.0 after fixing the link: %v", err)
+	}
+	validateUpdate(vi.Path(), nil, t)
+}
+
+func TestVersionedInstallerOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("versioned installs are not supported on Windows")
+	}
+	root := "TestVersionedInstallerOrder"
+	defer os.RemoveAll(root)
+
+	vi := &VersionedInstaller{Root: root, Name: "tool"}
+	for _, version := range []string{"1.0", "1.1"} {
+		if err := vi.Install(bytes.NewReader(newFile), version, Options{}); err != nil {
+			t.Fatalf("Failed to install version %s: %v", version, err)
+		}
+	}
+	if err := vi.Switch("1.0"); err != nil {
+		t.Fatalf("Failed to switch version: %v", err)
+	}
+
+	// modification times don't tell the order, e.g. after restoring a backup
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(vi.versionDir("1.0"), past, past); err != nil {
+		t.Fatalf("Failed to change modification time: %v", err)
+	}
+	versions, err := vi.Versions()
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0] != "1.1" || versions[1] != "1.0" {
+		t.Fatalf("Versions are not in the order they were used: %v", versions)
+	}
+
+	// versions missing from the order file are the least recent ones
+	if err := os.MkdirAll(vi.versionDir("0.9"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	vi.Keep = 2
+	if err := vi.Install(bytes.NewReader(newFile), "1.2", Options{}); err != nil {
+		t.Fatalf("Failed to install version 1.2: %v", err)
+	}
+	versions, err = vi.Versions()
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0] != "1.0" || versions[1] != "1.2" {
+		t.Fatalf("Pruned the wrong versions! Installed versions: %v", versions)
+	}
+}
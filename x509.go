@@ -0,0 +1,91 @@
+package update
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// CertificateChain is a code signing certificate along with the intermediate certificates needed
+// to chain it to a trusted root. It is distributed with each update and used as Options.PublicKey
+// together with the Verifier returned by NewX509Verifier.
+type CertificateChain struct {
+	// Leaf is the certificate of the key that signed the update.
+	Leaf *x509.Certificate
+
+	// Intermediates are the certificates between Leaf and a trusted root, in any order.
+	Intermediates []*x509.Certificate
+}
+
+// ParseCertificateChainPEM parses a chain of PEM encoded certificates, leaf first.
+func ParseCertificateChainPEM(pembytes []byte) (*CertificateChain, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pembytes = pem.Decode(pembytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("couldn't find any certificate in PEM data")
+	}
+	return &CertificateChain{Leaf: certs[0], Intermediates: certs[1:]}, nil
+}
+
+// X509Options configures the Verifier returned by NewX509Verifier.
+type X509Options struct {
+	// Roots are the trusted root certificates. It must not be nil.
+	Roots *x509.CertPool
+
+	// CurrentTime is the time at which the certificates must be valid. If zero, the current time is used.
+	CurrentTime time.Time
+
+	// Verifier verifies the signature with the public key of the leaf certificate once the chain
+	// is validated. If nil, the algorithm is picked from the type of the key, see NewAutoVerifier.
+	Verifier Verifier
+}
+
+// NewX509Verifier returns a Verifier that expects a *CertificateChain as the public key. It validates
+// the chain up to one of opts.Roots, requiring the code signing extended key usage, and then verifies
+// the signature with the public key of the leaf certificate.
+func NewX509Verifier(opts X509Options) Verifier {
+	if opts.Verifier == nil {
+		opts.Verifier = NewAutoVerifier()
+	}
+	return verifyFn(func(checksum, signature []byte, hash crypto.Hash, publicKey crypto.PublicKey) error {
+		chain, ok := publicKey.(*CertificateChain)
+		if !ok || chain.Leaf == nil {
+			return errors.New("not a valid certificate chain")
+		}
+		if opts.Roots == nil {
+			return errors.New("no root certificates to verify the certificate chain with")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain.Intermediates {
+			intermediates.AddCert(cert)
+		}
+		_, err := chain.Leaf.Verify(x509.VerifyOptions{
+			Roots:         opts.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   opts.CurrentTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		})
+		if err != nil {
+			return err
+		}
+
+		return opts.Verifier.VerifySignature(checksum, signature, hash, chain.Leaf.PublicKey)
+	})
+}
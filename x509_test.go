@@ -0,0 +1,102 @@
+package update
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func createCertificate(t *testing.T, template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func TestX509Verifier(t *testing.T) {
+	fName := "TestX509Verifier"
+	defer cleanup(fName)
+	writeOldFile(fName, t)
+
+	now := time.Now()
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	leaf := func(usage x509.ExtKeyUsage) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "release signing"},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+	}
+
+	root, rootKey := createCertificate(t, ca(1, "root"), nil, nil)
+	intermediate, intermediateKey := createCertificate(t, ca(2, "intermediate"), root, rootKey)
+	codeSigning, codeSigningKey := createCertificate(t, leaf(x509.ExtKeyUsageCodeSigning), intermediate, intermediateKey)
+	serverAuth, serverAuthKey := createCertificate(t, leaf(x509.ExtKeyUsageServerAuth), intermediate, intermediateKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	tests := []struct {
+		name  string
+		leaf  *x509.Certificate
+		key   crypto.Signer
+		chain []*x509.Certificate
+		time  time.Time
+		ok    bool
+	}{
+		{"Valid", codeSigning, codeSigningKey, []*x509.Certificate{intermediate}, time.Time{}, true},
+		{"NoIntermediate", codeSigning, codeSigningKey, nil, time.Time{}, false},
+		{"WrongUsage", serverAuth, serverAuthKey, []*x509.Certificate{intermediate}, time.Time{}, false},
+		{"Expired", codeSigning, codeSigningKey, []*x509.Certificate{intermediate}, now.Add(2 * time.Hour), false},
+		{"WrongKey", codeSigning, serverAuthKey, []*x509.Certificate{intermediate}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		sig, err := tt.key.Sign(rand.Reader, newFileChecksum[:], crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		err = Apply(bytes.NewReader(newFile), Options{
+			TargetPath: fName,
+			PublicKey:  &CertificateChain{Leaf: tt.leaf, Intermediates: tt.chain},
+			Signature:  sig,
+			Verifier:   NewX509Verifier(X509Options{Roots: roots, CurrentTime: tt.time}),
+		})
+		if tt.ok {
+			validateUpdate(fName, err, t)
+		} else if err == nil {
+			t.Fatalf("%s: Accepted an update with an invalid certificate chain!", tt.name)
+		}
+	}
+}
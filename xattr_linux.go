@@ -0,0 +1,25 @@
+package update
+
+import "syscall"
+
+// defaultPreserveXattrs carries file capabilities and the SELinux label over to the replacement.
+var defaultPreserveXattrs = []string{"security.capability", "security.selinux"}
+
+// copyXattr copies the extended attribute name from src to dst. It's not an error if src
+// doesn't have the attribute.
+func copyXattr(src, dst, name string) error {
+	size, err := syscall.Getxattr(src, name, nil)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(src, name, buf)
+	if err != nil {
+		return err
+	}
+	return syscall.Setxattr(dst, name, buf[:size], 0)
+}
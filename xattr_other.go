@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package update
+
+var defaultPreserveXattrs []string
+
+func copyXattr(src, dst, name string) error {
+	return errXattrUnsupported
+}